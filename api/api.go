@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"mime"
 	"net/http"
 	"net/url"
 	"path"
@@ -26,12 +27,13 @@ type contextKey int
 const apiVersionContextKey contextKey = 0
 
 type Handler struct {
-	db    core.MetadataDatabase
-	store core.BlobStore
+	db                core.MetadataDatabase
+	store             core.BlobStore
+	retentionPolicies []core.RetentionPolicy
 }
 
-func BuildRouter(db core.MetadataDatabase, store core.BlobStore, logRequests bool) http.Handler {
-	handler := Handler{db: db, store: store}
+func BuildRouter(db core.MetadataDatabase, store core.BlobStore, retentionPolicies []core.RetentionPolicy, logRequests bool) http.Handler {
+	handler := Handler{db: db, store: store, retentionPolicies: retentionPolicies}
 	r := chi.NewRouter()
 
 	r.Use(createRequestIdMiddleware)
@@ -45,9 +47,29 @@ func BuildRouter(db core.MetadataDatabase, store core.BlobStore, logRequests boo
 		r.Route("/blobs", func(r chi.Router) {
 			r.Post("/data", handler.CreateBlob)
 			r.Get("/", handler.SearchBlobs)
+			r.Delete("/", handler.DeleteBlobs)
+			r.Get("/count", handler.CountBlobs)
+			r.Get("/facets", handler.GetBlobFacets)
+			r.Get("/archive", handler.GetBlobArchive)
+			r.Post("/archive", handler.ImportBlobArchive)
+			r.Get("/latest", handler.GetLatestBlobMetadata)
+			r.Post("/latest", handler.BatchGetLatestBlobs)
 			r.Get("/data/latest", handler.GetLatestBlobData)
+			r.Head("/data/latest", handler.GetLatestBlobData)
 			r.Get("/{combined-id}", handler.MakeBlobEndpoint(handler.BlobMetadataResponse, 0*time.Second))
-			r.Get("/{combined-id}/data", handler.MakeBlobEndpoint(handler.BlobDataResponse, 30*time.Minute))
+			r.Patch("/{combined-id}", handler.UpdateBlobMetadata)
+			r.Delete("/{combined-id}", handler.DeleteBlob)
+			r.Get("/{combined-id}/data", handler.MakeBlobEndpoint(handler.CacheableBlobDataResponse, 30*time.Minute))
+			r.Head("/{combined-id}/data", handler.MakeBlobEndpoint(handler.CacheableBlobDataResponse, 30*time.Minute))
+			r.Put("/{combined-id}/hold", handler.PlaceLegalHold)
+			r.Delete("/{combined-id}/hold", handler.ReleaseLegalHold)
+		})
+		r.Get("/subjects", handler.MakeDistinctValuesEndpoint(core.DistinctSubjects, false))
+		r.Get("/sessions", handler.MakeDistinctValuesEndpoint(core.DistinctSessions, true))
+		r.Get("/devices", handler.MakeDistinctValuesEndpoint(core.DistinctDevices, false))
+		r.Get("/tags", handler.MakeDistinctValuesEndpoint(core.DistinctTagNames, false))
+		r.Route("/admin", func(r chi.Router) {
+			r.Delete("/subjects", handler.PurgeSubject)
 		})
 	})
 
@@ -154,6 +176,7 @@ func getBaseUri(r *http.Request) url.URL {
 	url.Host = r.Host
 
 	url.RawQuery = ""
+	url.ForceQuery = false
 
 	// the root path segment should be the current api version
 	url.Path = r.Context().Value(apiVersionContextKey).(string)
@@ -184,7 +207,22 @@ func CreateBlobInfo(r *http.Request, blob *core.BlobInfo) map[string]interface{}
 		info["expires"] = blob.ExpiresAt.UTC().Format(time.RFC3339Nano)
 	}
 
+	if blob.IdleTimeToLive != nil {
+		info["idleTtl"] = blob.IdleTimeToLive.String()
+	}
+
+	if blob.LegalHoldReason != nil {
+		info["legalHold"] = true
+		info["legalHoldReason"] = *blob.LegalHoldReason
+	}
+
 	info["subject"] = blob.Key.Subject
+	if blob.Size != nil {
+		info["size"] = *blob.Size
+	}
+	if blob.Sha256 != nil {
+		info["digest"] = formatSha256Digest(blob.Sha256)
+	}
 	if blob.Tags.ContentType != nil {
 		info["contentType"] = blob.Tags.ContentType
 	}
@@ -211,6 +249,60 @@ func CreateBlobInfo(r *http.Request, blob *core.BlobInfo) map[string]interface{}
 	return info
 }
 
+// Parses the values of the `_fields` parameter, which are comma-separated lists of the fields to include in
+// blob metadata responses. Field names are not case-sensitive. Returns nil if no values are given, meaning
+// that all fields are included.
+func getFieldProjection(w http.ResponseWriter, r *http.Request, values []string) (fields map[string]bool, ok bool) {
+	if len(values) == 0 {
+		return nil, true
+	}
+
+	fields = make(map[string]bool)
+	for _, v := range values {
+		for _, field := range strings.Split(v, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				fields[strings.ToLower(field)] = true
+			}
+		}
+	}
+
+	if len(fields) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJson(w, r, CreateErrorResponse("InvalidParameter", "The '_fields' parameter must list at least one field."))
+		return nil, false
+	}
+
+	return fields, true
+}
+
+// Removes the fields of a CreateBlobInfo document that are not in the projection. A nil projection keeps all fields.
+func projectBlobInfo(info map[string]interface{}, fields map[string]bool) map[string]interface{} {
+	if fields == nil {
+		return info
+	}
+
+	for k := range info {
+		if !fields[strings.ToLower(k)] {
+			delete(info, k)
+		}
+	}
+
+	return info
+}
+
+// Returns true if the request's Accept header lists the given media type
+func acceptsMediaType(r *http.Request, mediaType string) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, acceptedType := range strings.Split(accept, ",") {
+			if parsed, _, err := mime.ParseMediaType(acceptedType); err == nil && parsed == mediaType {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 func writeJson(w http.ResponseWriter, r *http.Request, v interface{}) {
 	buf := &bytes.Buffer{}
 	enc := json.NewEncoder(buf)
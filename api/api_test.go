@@ -37,7 +37,7 @@ func TestHealthCheck(t *testing.T) {
 				HealthCheck(gomock.Any()).
 				Return(tc.storageErr)
 
-			handler := BuildRouter(mockMetadataDatabase, mockBlobStore, false)
+			handler := BuildRouter(mockMetadataDatabase, mockBlobStore, nil, false)
 
 			req := httptest.NewRequest("GET", "/healthcheck", nil)
 			resp := httptest.NewRecorder()
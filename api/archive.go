@@ -0,0 +1,243 @@
+package api
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/ismrmrd/mrd-storage-server/core"
+)
+
+const (
+	ArchiveFormatTar = "tar"
+	ArchiveFormatZip = "zip"
+
+	// The name of the archive entry listing the metadata of each blob
+	ArchiveManifestName = "manifest.json"
+
+	// The directory of the archive that contains the blob contents
+	archiveBlobDirectory = "blobs"
+
+	// The maximum number of blobs in an archive, which bounds the size of the manifest held in memory
+	maxArchiveBlobs = 10000
+)
+
+var archiveContentTypes = map[string]string{
+	ArchiveFormatTar: "application/x-tar",
+	ArchiveFormatZip: "application/zip",
+}
+
+// Writes entries to a tar or zip archive
+type archiveWriter interface {
+	WriteEntry(name string, size int64, modified time.Time, contents io.Reader) error
+	Close() error
+}
+
+type tarArchiveWriter struct {
+	*tar.Writer
+}
+
+func (w tarArchiveWriter) WriteEntry(name string, size int64, modified time.Time, contents io.Reader) error {
+	header := &tar.Header{Typeflag: tar.TypeReg, Name: name, Size: size, Mode: 0644, ModTime: modified, Format: tar.FormatPAX}
+	if err := w.WriteHeader(header); err != nil {
+		return err
+	}
+
+	_, err := io.Copy(w, contents)
+	return err
+}
+
+type zipArchiveWriter struct {
+	*zip.Writer
+}
+
+func (w zipArchiveWriter) WriteEntry(name string, size int64, modified time.Time, contents io.Reader) error {
+	// blobs are stored without compression because most of them are large binary data
+	entry, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: modified})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(entry, contents)
+	return err
+}
+
+// Reads the files of a tar or zip archive
+type archiveReader interface {
+	// Returns the name and content of the next file in the archive, or io.EOF if there are no more files
+	Next() (name string, contents io.ReadCloser, err error)
+}
+
+type tarArchiveReader struct {
+	*tar.Reader
+}
+
+func (r tarArchiveReader) Next() (string, io.ReadCloser, error) {
+	for {
+		header, err := r.Reader.Next()
+		if err != nil {
+			return "", nil, err
+		}
+
+		if header.Typeflag == tar.TypeReg {
+			return header.Name, io.NopCloser(r.Reader), nil
+		}
+	}
+}
+
+type zipArchiveReader struct {
+	files []*zip.File
+}
+
+// Creates a reader returning the manifest before the other files, wherever it is in the archive
+func newZipArchiveReader(reader *zip.Reader) *zipArchiveReader {
+	files := make([]*zip.File, 0, len(reader.File))
+	for _, f := range reader.File {
+		if f.Name == ArchiveManifestName {
+			files = append([]*zip.File{f}, files...)
+		} else if !f.FileInfo().IsDir() {
+			files = append(files, f)
+		}
+	}
+
+	return &zipArchiveReader{files: files}
+}
+
+func (r *zipArchiveReader) Next() (string, io.ReadCloser, error) {
+	if len(r.files) == 0 {
+		return "", nil, io.EOF
+	}
+
+	f := r.files[0]
+	r.files = r.files[1:]
+	contents, err := f.Open()
+	return f.Name, contents, err
+}
+
+// Returns the name of the archive entry with the content of the given blob
+func ArchiveBlobEntryName(key core.BlobKey) string {
+	return path.Join(archiveBlobDirectory, key.Id.String())
+}
+
+// Streams a tar or zip archive of all the blobs matching a search query. The first entry of the archive is
+// a manifest listing the metadata of each blob, followed by an entry for each blob. The manifest is built in
+// memory, so queries matching more than maxArchiveBlobs blobs are rejected. The blobs themselves are read from
+// the store one at a time and copied to the response as they are read.
+func (handler *Handler) GetBlobArchive(w http.ResponseWriter, r *http.Request) {
+
+	filter, options, _, _, ok := getSearchParameters(w, r, "_sort", "_format")
+	if !ok {
+		return
+	}
+
+	sort, ok := getSortOrder(w, r, options)
+	if !ok {
+		return
+	}
+
+	format := ArchiveFormatTar
+	if formats, ok := options["_format"]; ok {
+		format = formats[0]
+		if _, ok := archiveContentTypes[format]; !ok || len(formats) > 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJson(w, r, CreateErrorResponse("InvalidParameter", "The '_format' parameter must be 'tar' or 'zip'."))
+			return
+		}
+	}
+
+	// The metadata of all blobs is read up front so that the manifest can be the first entry.
+	// This allows the archive to be processed as a stream.
+	now := time.Now()
+	blobs := []core.BlobInfo{}
+	for ct := (*core.ContinutationToken)(nil); ; {
+		results, nextCt, err := handler.db.SearchBlobMetadata(r.Context(), filter, sort, ct, streamPageSize, now)
+		if err != nil {
+			writeSearchError(w, r, err)
+			return
+		}
+
+		blobs = append(blobs, results...)
+		if len(blobs) > maxArchiveBlobs {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJson(w, r, CreateErrorResponse("TooManyResults", fmt.Sprintf("The query matches more than %d blobs. Narrow it, for example with '_createdAfter' and '_createdBefore', and export the blobs in several archives.", maxArchiveBlobs)))
+			return
+		}
+
+		if nextCt == nil {
+			break
+		}
+
+		ct = nextCt
+	}
+
+	manifest := SearchResponse{Items: make([]map[string]interface{}, len(blobs))}
+	for i := range blobs {
+		manifest.Items[i] = CreateBlobInfo(r, &blobs[i])
+	}
+
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", archiveContentTypes[format])
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": "blobs." + format}))
+	w.WriteHeader(http.StatusOK)
+
+	var archive archiveWriter
+	if format == ArchiveFormatZip {
+		archive = zipArchiveWriter{zip.NewWriter(w)}
+	} else {
+		archive = tarArchiveWriter{tar.NewWriter(w)}
+	}
+
+	if err := archive.WriteEntry(ArchiveManifestName, int64(len(manifestBytes)), now, bytes.NewReader(manifestBytes)); err != nil {
+		abortStreamedResponse(r, "Failed to write archive: %v", err)
+		return
+	}
+
+	for i := range blobs {
+		if err := handler.writeBlobToArchive(r, archive, &blobs[i]); err != nil {
+			abortStreamedResponse(r, "Failed to write archive: %v", err)
+			return
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		abortStreamedResponse(r, "Failed to write archive: %v", err)
+	}
+}
+
+func (handler *Handler) writeBlobToArchive(r *http.Request, archive archiveWriter, blobInfo *core.BlobInfo) error {
+	blob, err := handler.store.OpenBlob(r.Context(), blobInfo.Key)
+	if err != nil {
+		return fmt.Errorf("failed to read blob %v: %w", blobInfo.Key.Id, err)
+	}
+
+	defer blob.Close()
+
+	// tar headers need the size before the content
+	size, err := blob.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	if _, err := blob.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if err := archive.WriteEntry(ArchiveBlobEntryName(blobInfo.Key), size, blobInfo.CreatedAt, blob); err != nil {
+		return err
+	}
+
+	handler.refreshIdleExpiration(r.Context(), blobInfo)
+	return nil
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/ismrmrd/mrd-storage-server/core"
+	"github.com/ismrmrd/mrd-storage-server/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure that an archive is not started when the query matches too many blobs
+// for the manifest, and that no blob is read from the store.
+func TestArchiveOfTooManyBlobsIsRejected(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockMetadataDatabase := mocks.NewMockMetadataDatabase(mockCtrl)
+	mockBlobStore := mocks.NewMockBlobStore(mockCtrl)
+
+	ct := core.ContinutationToken("next")
+	mockMetadataDatabase.EXPECT().
+		SearchBlobMetadata(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), streamPageSize, gomock.Any()).
+		DoAndReturn(func(ctx context.Context, filter *core.SearchFilter, sort core.SortOrder, _ *core.ContinutationToken, pageSize int, expiresAfter time.Time) ([]core.BlobInfo, *core.ContinutationToken, error) {
+			results := make([]core.BlobInfo, pageSize)
+			for i := range results {
+				results[i].Key = core.BlobKey{Subject: "a", Id: uuid.New()}
+			}
+
+			return results, &ct, nil
+		}).
+		Times(maxArchiveBlobs/streamPageSize + 1)
+
+	router := BuildRouter(mockMetadataDatabase, mockBlobStore, nil, false)
+
+	req := httptest.NewRequest("GET", "/v1/blobs/archive?subject=a", nil)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	errorResp := ErrorResponse{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&errorResp))
+	assert.Equal(t, "TooManyResults", errorResp.Error.Code)
+}
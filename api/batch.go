@@ -0,0 +1,197 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ismrmrd/mrd-storage-server/core"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	maxBatchQueries = 100
+
+	// The maximum number of batch queries executed against the database at the same time
+	batchQueryConcurrency = 8
+)
+
+// The options that a query in a batch request can have. The other search options, like `_limit`, `_ct`, and
+// `_sort`, do not apply to a lookup of the latest blob, and response options are given in the query string.
+var batchQueryOptions = map[string]bool{
+	"_at":            true,
+	"_createdafter":  true,
+	"_createdbefore": true,
+	"_legalhold":     true,
+}
+
+// A query in a batch request. Keys are the same as query string parameters of a search and
+// values are either a string or an array of strings.
+type batchQuery url.Values
+
+func (q *batchQuery) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	values := make(url.Values)
+	for k, v := range raw {
+		var single string
+		if err := json.Unmarshal(v, &single); err == nil {
+			values[k] = append(values[k], single)
+			continue
+		}
+
+		var multiple []string
+		if err := json.Unmarshal(v, &multiple); err != nil {
+			return fmt.Errorf("the value of '%s' must be a string or an array of strings", k)
+		}
+
+		values[k] = append(values[k], multiple...)
+	}
+
+	*q = batchQuery(normalizeQueryMapToLowercaseKeys(values))
+	return nil
+}
+
+// Returns the metadata of the latest blob matching each of the queries in the request body, which is
+// a JSON array of objects. The results are in the same order as the queries. With the `_include=data`
+// option, the content of small blobs is included as well.
+func (handler *Handler) BatchGetLatestBlobs(w http.ResponseWriter, r *http.Request) {
+	options := normalizeQueryMapToLowercaseKeys(r.URL.Query())
+
+	fields, ok := getFieldProjection(w, r, options["_fields"])
+	if !ok {
+		return
+	}
+
+	maxInlineSize, ok := getInlineOptions(w, r, options)
+	if !ok {
+		return
+	}
+
+	var queries []batchQuery
+	if err := json.NewDecoder(r.Body).Decode(&queries); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJson(w, r, CreateErrorResponse("InvalidBody", fmt.Sprintf("The request body must be a JSON array of queries: %v", err)))
+		return
+	}
+
+	if len(queries) == 0 || len(queries) > maxBatchQueries {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJson(w, r, CreateErrorResponse("InvalidBody", fmt.Sprintf("The request must contain between 1 and %d queries.", maxBatchQueries)))
+		return
+	}
+
+	filters := make([]*core.SearchFilter, len(queries))
+	for i, query := range queries {
+		var err *searchParameterError
+		for key := range query {
+			if strings.HasPrefix(key, "_") && !batchQueryOptions[key] {
+				err = &searchParameterError{"InvalidParameter", fmt.Sprintf("The '%s' parameter is not supported in batch queries.", key)}
+				break
+			}
+		}
+
+		var filter *core.SearchFilter
+		if err == nil {
+			filter, _, _, _, err = parseSearchParameters(url.Values(query))
+		}
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJson(w, r, CreateErrorResponse(err.code, fmt.Sprintf("Query %d: %s", i, err.message)))
+			return
+		}
+
+		filters[i] = filter
+	}
+
+	blobs, err := handler.findLatestBlobs(r, filters)
+	if err != nil {
+		log.Ctx(r.Context()).Error().Msgf("Failed to search blobs in DB: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]map[string]interface{}, len(blobs))
+	for i, blob := range blobs {
+		if blob != nil {
+			items[i] = projectBlobInfo(CreateBlobInfo(r, blob), fields)
+		}
+	}
+
+	if maxInlineSize > 0 {
+		if err := handler.inlineBlobData(r.Context(), blobs, items, maxInlineSize); err != nil {
+			log.Ctx(r.Context()).Error().Msgf("Failed to read blob from storage: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	response := BatchResponse{Results: make([]BatchResult, len(items))}
+	for i, item := range items {
+		if item == nil {
+			response.Results[i] = BatchResult{Status: http.StatusNotFound, Error: &ErrorInfo{Code: "EmptyResults", Message: "The search returned no results."}}
+		} else {
+			response.Results[i] = BatchResult{Status: http.StatusOK, Item: item}
+		}
+	}
+
+	writeJson(w, r, response)
+
+	// Blobs whose content was inlined have already been refreshed, which makes this a no-op for them
+	for _, blob := range blobs {
+		if blob != nil {
+			handler.refreshIdleExpiration(r.Context(), blob)
+		}
+	}
+}
+
+// Runs a search for the latest blob matching each filter, with a bounded number of searches in progress
+// at the same time. The result for a filter without matches is nil.
+func (handler *Handler) findLatestBlobs(r *http.Request, filters []*core.SearchFilter) ([]*core.BlobInfo, error) {
+	blobs := make([]*core.BlobInfo, len(filters))
+	indexes := make(chan int)
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+
+	for i := 0; i < batchQueryConcurrency && i < len(filters); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				results, _, err := handler.db.SearchBlobMetadata(r.Context(), filters[index], core.SortOrder{}, nil, 1, now)
+				if err != nil {
+					errOnce.Do(func() { firstErr = err })
+					continue
+				}
+
+				if len(results) > 0 {
+					blobs[index] = &results[0]
+				}
+			}
+		}()
+	}
+
+	for i := range filters {
+		indexes <- i
+	}
+
+	close(indexes)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	return blobs, nil
+}
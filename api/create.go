@@ -1,8 +1,10 @@
 package api
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"regexp"
 	"time"
@@ -19,17 +21,26 @@ const (
 
 var (
 	reservedTagNames = map[string]bool{
-		"location":      true,
-		"last-modified": true,
-		"lastmodified":  true,
-		"content-type":  true,
-		"contenttype":   true,
+		"location":        true,
+		"last-modified":   true,
+		"lastmodified":    true,
+		"content-type":    true,
+		"contenttype":     true,
+		"size":            true,
+		"digest":          true,
+		"idlettl":         true,
+		"legalhold":       true,
+		"legalholdreason": true,
 	}
 	tagNameRegex, _     = regexp.Compile(`(^[a-zA-Z][a-zA-Z0-9_\-]{0,63}$)|$null`)
 	commonTagValidator  = CombineTagValidators(ValidateTagName, ValidateGenericTagValues)
 	systemTagValidator  = CombineTagValidators(commonTagValidator, ValidateOnlyOneTag)
 	subjectTagValidator = CombineTagValidators(ValidateSubjectTagValue, systemTagValidator)
 	ttlTagValidator     = CombineTagValidators(ValidateTimeToLive, ValidateGenericTagValues)
+	expiresAtValidator  = CombineTagValidators(ValidateOnlyOneTag, ValidateExpiresAt)
+	idleTtlTagValidator = CombineTagValidators(ValidateOnlyOneTag, ValidateTimeToLive)
+
+	errMultipleExpirationOptions = errors.New("only one of '_ttl', '_expiresAt', and '_idleTtl' can be given")
 )
 
 type TagValidator func(tagName string, tagValues []string) error
@@ -69,6 +80,20 @@ func (handler *Handler) CreateBlob(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if !hasExpirationOption(&tags) {
+		if policy := core.FindRetentionPolicy(handler.retentionPolicies, key, &tags); policy != nil {
+			timeToLive := policy.TimeToLive.String()
+			tags.TimeToLive = &timeToLive
+		}
+	}
+
+	expectedDigests, err := getExpectedDigests(r.Header)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJson(w, r, CreateErrorResponse("InvalidDigest", err.Error()))
+		return
+	}
+
 	blobInfo, err := handler.db.StageBlobMetadata(r.Context(), key, &tags)
 	if err != nil {
 		log.Ctx(r.Context()).Error().Msgf("Failed to stage blob metadata: %v", err)
@@ -76,7 +101,14 @@ func (handler *Handler) CreateBlob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := handler.store.SaveBlob(r.Context(), r.Body, key); err != nil {
+	hashes := createContentHashes(expectedDigests)
+	hashWriters := make([]io.Writer, 0, len(hashes))
+	for _, h := range hashes {
+		hashWriters = append(hashWriters, h)
+	}
+
+	body := &core.CountingReader{Reader: io.TeeReader(r.Body, io.MultiWriter(hashWriters...))}
+	if err := handler.store.SaveBlob(r.Context(), body, key); err != nil {
 		log.Ctx(r.Context()).Error().Msgf("Failed to save blob: %v", err)
 
 		err = handler.db.DeleteBlobMetadata(r.Context(), key)
@@ -87,12 +119,35 @@ func (handler *Handler) CreateBlob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := handler.db.CompleteStagedBlobMetadata(r.Context(), key); err != nil {
+	for algorithm, expectedDigest := range expectedDigests {
+		if !bytes.Equal(expectedDigest, hashes[algorithm].Sum(nil)) {
+			log.Ctx(r.Context()).Warn().Msgf("The %s digest of the uploaded content does not match the request", algorithm)
+
+			// If the blob cannot be deleted, we leave the staged metadata so that
+			// garbage collection will clean up both later.
+			if err := handler.store.DeleteBlob(r.Context(), key); err != nil {
+				log.Ctx(r.Context()).Error().Msgf("Failed to delete blob: %v", err)
+			} else if err := handler.db.DeleteBlobMetadata(r.Context(), key); err != nil {
+				log.Ctx(r.Context()).Error().Msgf("Failed to revert staged blob metadata: %v", err)
+			}
+
+			w.WriteHeader(http.StatusBadRequest)
+			writeJson(w, r, CreateErrorResponse("DigestMismatch", fmt.Sprintf("The %s digest of the content does not match the digest given in the request headers.", algorithm)))
+			return
+		}
+	}
+
+	sha256 := hashes[DigestAlgorithmSha256].Sum(nil)
+
+	if err := handler.db.CompleteStagedBlobMetadata(r.Context(), key, body.Count, sha256, nil); err != nil {
 		log.Ctx(r.Context()).Error().Msgf("Failed to complete staged metadata to database: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	blobInfo.Size = &body.Count
+	blobInfo.Sha256 = sha256
+
 	w.WriteHeader(http.StatusCreated)
 	writeJson(w, r, CreateBlobInfo(r, blobInfo))
 }
@@ -154,6 +209,27 @@ func ValidateTimeToLive(tagName string, tagValues []string) error {
 	return nil
 }
 
+func ValidateExpiresAt(tagName string, tagValues []string) error {
+
+	for _, t := range tagValues {
+		expiresAt, err := time.Parse(time.RFC3339Nano, t)
+
+		if err != nil {
+			return fmt.Errorf("%s: the time must be in the format 2006-01-02T15:04:05Z07:00", tagName)
+		}
+
+		if !expiresAt.After(time.Now()) {
+			return fmt.Errorf("%s: the expiration time must be in the future", tagName)
+		}
+	}
+
+	return nil
+}
+
+func hasExpirationOption(tags *core.BlobTags) bool {
+	return tags.TimeToLive != nil || tags.ExpiresAt != nil || tags.IdleTimeToLive != nil
+}
+
 func CombineTagValidators(validators ...TagValidator) TagValidator {
 	return func(tagName string, tagValues []string) error {
 		for _, v := range validators {
@@ -184,7 +260,24 @@ func ValidateAndStoreTag(tags *core.BlobTags, tagName string, tagValues []string
 	case "session":
 		return ValidateAndStoreOptionalSystemTag(tagName, tagValues, &tags.Session, systemTagValidator)
 	case "_ttl":
+		if hasExpirationOption(tags) {
+			return errMultipleExpirationOptions
+		}
 		return ValidateAndStoreOptionalSystemTag(tagName, tagValues, &tags.TimeToLive, ttlTagValidator)
+	case "_idlettl":
+		if hasExpirationOption(tags) {
+			return errMultipleExpirationOptions
+		}
+		return ValidateAndStoreOptionalSystemTag(tagName, tagValues, &tags.IdleTimeToLive, idleTtlTagValidator)
+	case "_expiresat":
+		if hasExpirationOption(tags) {
+			return errMultipleExpirationOptions
+		}
+		if err := expiresAtValidator(tagName, tagValues); err != nil {
+			return err
+		}
+		expiresAt, _ := time.Parse(time.RFC3339Nano, tagValues[0])
+		tags.ExpiresAt = &expiresAt
 	default:
 		if err := commonTagValidator(tagName, tagValues); err != nil {
 			return err
@@ -1,6 +1,9 @@
 package api
 
 import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
 	"errors"
 	"io"
 	"net/http"
@@ -12,6 +15,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/golang/mock/gomock"
+	"github.com/ismrmrd/mrd-storage-server/core"
 	"github.com/ismrmrd/mrd-storage-server/mocks"
 )
 
@@ -46,6 +50,43 @@ func TestStorageWriteFailureRevertsStagedMetadata(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, resp.Result().StatusCode)
 }
 
+func TestDigestMismatchRevertsStagedMetadata(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockMetadataDatabase := mocks.NewMockMetadataDatabase(mockCtrl)
+	mockBlobStore := mocks.NewMockBlobStore(mockCtrl)
+
+	mockMetadataDatabase.EXPECT().
+		StageBlobMetadata(gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(&core.BlobInfo{}, nil)
+
+	mockBlobStore.EXPECT().
+		SaveBlob(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, contents io.Reader, key core.BlobKey) error {
+			_, err := io.Copy(io.Discard, contents)
+			return err
+		})
+
+	gomock.InOrder(
+		mockBlobStore.EXPECT().
+			DeleteBlob(gomock.Any(), gomock.Any()).
+			Return(nil),
+		mockMetadataDatabase.EXPECT().
+			DeleteBlobMetadata(gomock.Any(), gomock.Any()).
+			Return(nil),
+	)
+
+	handler := Handler{db: mockMetadataDatabase, store: mockBlobStore}
+
+	req := httptest.NewRequest("POST", "/v1/blobs?subject=a", strings.NewReader("content"))
+	md5OfOtherContent := md5.Sum([]byte("other content"))
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(md5OfOtherContent[:]))
+	resp := httptest.NewRecorder()
+
+	handler.CreateBlob(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Result().StatusCode)
+}
+
 func TestStagingFailureResultsInAbortedRequest(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	mockMetadataDatabase := mocks.NewMockMetadataDatabase(mockCtrl)
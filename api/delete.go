@@ -0,0 +1,83 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ismrmrd/mrd-storage-server/core"
+	"github.com/rs/zerolog/log"
+)
+
+// The maximum number of blobs deleted by a single request to delete the blobs matching a query,
+// so that the request completes in a bounded time. Clients repeat the request to delete the rest.
+const maxDeletedBlobsPerRequest = 1000
+
+func (handler *Handler) DeleteBlob(w http.ResponseWriter, r *http.Request) {
+
+	combinedId := chi.URLParam(r, "combined-id")
+	key, ok := getBlobSubjectAndIdFromCombinedId(combinedId)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err := core.DeleteBlob(r.Context(), handler.db, handler.store, key); err != nil {
+		if errors.Is(err, core.ErrRecordNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if errors.Is(err, core.ErrLegalHold) {
+			w.WriteHeader(http.StatusConflict)
+			writeJson(w, r, CreateErrorResponse("LegalHold", "The blob is under legal hold and cannot be deleted."))
+			return
+		}
+
+		log.Ctx(r.Context()).Error().Msgf("Failed to delete blob: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (handler *Handler) DeleteBlobs(w http.ResponseWriter, r *http.Request) {
+
+	filter, options, _, _, ok := getSearchParameters(w, r, "_dryrun")
+	if !ok {
+		return
+	}
+
+	dryRun, ok := getFlagOption(w, r, options, "_dryRun")
+	if !ok {
+		return
+	}
+
+	var count int
+	var remaining int64
+	var err error
+	if dryRun {
+		// blobs under legal hold would not be deleted
+		if deletable := core.ExcludeBlobsOnLegalHold(filter); deletable != nil {
+			var matching int64
+			matching, err = handler.db.CountBlobMetadata(r.Context(), deletable, time.Now())
+			count = int(matching)
+		}
+	} else {
+		count, remaining, err = core.DeleteMatchingBlobs(r.Context(), handler.db, handler.store, filter, maxDeletedBlobsPerRequest)
+	}
+
+	if err != nil {
+		log.Ctx(r.Context()).Error().Msgf("Failed to delete blobs: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		if !dryRun {
+			writeJson(w, r, CreateErrorResponse("DeleteIncomplete", fmt.Sprintf("The deletion failed after deleting %d blobs. Retry the request to delete the rest.", count)))
+		}
+		return
+	}
+
+	writeJson(w, r, DeleteResponse{Count: count, DryRun: dryRun, Remaining: remaining})
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/ismrmrd/mrd-storage-server/core"
+	"github.com/ismrmrd/mrd-storage-server/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure that when deleting the blobs matching a query fails partway through,
+// the response says how many blobs were deleted.
+func TestDeleteBlobsFailureReportsDeletedCount(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockMetadataDatabase := mocks.NewMockMetadataDatabase(mockCtrl)
+	mockBlobStore := mocks.NewMockBlobStore(mockCtrl)
+
+	deletedKey := core.BlobKey{Subject: "a", Id: uuid.New()}
+	failedKey := core.BlobKey{Subject: "a", Id: uuid.New()}
+
+	mockMetadataDatabase.EXPECT().
+		SearchBlobMetadata(gomock.Any(), gomock.Any(), gomock.Any(), nil, gomock.Any(), gomock.Any()).
+		Return([]core.BlobInfo{{Key: deletedKey}, {Key: failedKey}}, nil, nil)
+	mockMetadataDatabase.EXPECT().StageBlobMetadataForDeletion(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+	mockBlobStore.EXPECT().DeleteBlob(gomock.Any(), deletedKey).Return(nil)
+	mockMetadataDatabase.EXPECT().DeleteBlobMetadata(gomock.Any(), deletedKey).Return(nil)
+	mockBlobStore.EXPECT().DeleteBlob(gomock.Any(), failedKey).Return(errors.New("failed to delete"))
+
+	router := BuildRouter(mockMetadataDatabase, mockBlobStore, nil, false)
+
+	req := httptest.NewRequest("DELETE", "/v1/blobs?subject=a", nil)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusInternalServerError, resp.Code)
+	errorResp := ErrorResponse{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&errorResp))
+	assert.Equal(t, "DeleteIncomplete", errorResp.Error.Code)
+	assert.Contains(t, errorResp.Error.Message, "after deleting 1 blobs")
+}
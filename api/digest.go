@@ -0,0 +1,91 @@
+package api
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+)
+
+const (
+	DigestAlgorithmSha256 = "sha-256"
+	DigestAlgorithmMd5    = "md5"
+)
+
+var (
+	// Algorithms that can be given in the Digest header (RFC 3230). Others are ignored.
+	supportedDigestAlgorithms = map[string]func() hash.Hash{
+		DigestAlgorithmSha256: sha256.New,
+		DigestAlgorithmMd5:    md5.New,
+	}
+)
+
+// Gets the digests of the request content that the client supplied in the Content-MD5 and Digest headers,
+// keyed by algorithm name.
+func getExpectedDigests(header http.Header) (map[string][]byte, error) {
+	expected := make(map[string][]byte)
+
+	if contentMd5 := header.Get("Content-MD5"); contentMd5 != "" {
+		value, err := base64.StdEncoding.DecodeString(contentMd5)
+		if err != nil || len(value) != md5.Size {
+			return nil, errors.New("the Content-MD5 header is invalid")
+		}
+
+		expected[DigestAlgorithmMd5] = value
+	}
+
+	for _, headerValue := range header.Values("Digest") {
+		for _, instanceDigest := range strings.Split(headerValue, ",") {
+			algorithm, encodedValue, found := strings.Cut(strings.TrimSpace(instanceDigest), "=")
+			if !found {
+				return nil, errors.New("the Digest header is invalid")
+			}
+
+			algorithm = strings.ToLower(algorithm)
+			newHash, supported := supportedDigestAlgorithms[algorithm]
+			if !supported {
+				continue
+			}
+
+			value, err := base64.StdEncoding.DecodeString(encodedValue)
+			if err != nil || len(value) != newHash().Size() {
+				return nil, fmt.Errorf("the %s value in the Digest header is invalid", algorithm)
+			}
+
+			if existing, ok := expected[algorithm]; ok && string(existing) != string(value) {
+				return nil, fmt.Errorf("conflicting %s digests were given", algorithm)
+			}
+
+			expected[algorithm] = value
+		}
+	}
+
+	return expected, nil
+}
+
+// Creates the hashes to compute over the request content: SHA-256, which is always
+// stored, plus any other algorithms the client supplied a digest for.
+func createContentHashes(expectedDigests map[string][]byte) map[string]hash.Hash {
+	hashes := map[string]hash.Hash{DigestAlgorithmSha256: sha256.New()}
+	for algorithm := range expectedDigests {
+		if _, ok := hashes[algorithm]; !ok {
+			hashes[algorithm] = supportedDigestAlgorithms[algorithm]()
+		}
+	}
+
+	return hashes
+}
+
+// Formats a SHA-256 hash the way it appears in a Digest header
+func formatSha256Digest(sha256 []byte) string {
+	return DigestAlgorithmSha256 + "=" + base64.StdEncoding.EncodeToString(sha256)
+}
+
+func formatSha256ETag(sha256 []byte) string {
+	return `"` + hex.EncodeToString(sha256) + `"`
+}
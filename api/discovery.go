@@ -0,0 +1,84 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ismrmrd/mrd-storage-server/core"
+	"github.com/rs/zerolog/log"
+)
+
+// Creates a handler that lists the distinct values of the given kind, for example all subjects in the store.
+// If subjectRequired is true, the `subject` query parameter must be given. Otherwise, it optionally restricts
+// the values to those of blobs with that subject.
+func (handler *Handler) MakeDistinctValuesEndpoint(kind core.DistinctValueKind, subjectRequired bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := normalizeQueryMapToLowercaseKeys(r.URL.Query())
+
+		options := make(url.Values)
+		for key, values := range query {
+			if strings.HasPrefix(key, "_") {
+				options[key] = values
+			}
+		}
+
+		if err := checkSupportedOptions(options, []string{"_limit", "_ct"}); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJson(w, r, CreateErrorResponse(err.code, err.message))
+			return
+		}
+
+		var subject *string
+		if subjectStrings, hasSubject := query["subject"]; hasSubject {
+			if len(subjectStrings) > 1 {
+				w.WriteHeader(http.StatusBadRequest)
+				writeJson(w, r, CreateErrorResponse("InvalidParameter", "The 'subject' parameter was specified multiple times in the URL."))
+				return
+			}
+
+			subject = &subjectStrings[0]
+		} else if subjectRequired {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJson(w, r, CreateErrorResponse("InvalidQuery", "'subject' query parameter is mandatory."))
+			return
+		}
+
+		pageSize := 100
+		if limitStrings, hasLimit := query["_limit"]; hasLimit {
+			pageSize, _ = strconv.Atoi(limitStrings[0])
+			if pageSize > 100 || pageSize <= 0 {
+				pageSize = 100
+			}
+		}
+
+		var ct *core.ContinutationToken
+		if cts, hasCt := query["_ct"]; hasCt {
+			if len(cts) > 1 {
+				w.WriteHeader(http.StatusBadRequest)
+				writeJson(w, r, CreateErrorResponse("InvalidContinuationToken", "The'_ct' parameter was specified multiple times in the URL."))
+				return
+			}
+
+			ct = (*core.ContinutationToken)(&cts[0])
+		}
+
+		values, ct, err := handler.db.GetPageOfDistinctValues(r.Context(), kind, subject, ct, pageSize, time.Now())
+		if err != nil {
+			if errors.Is(err, core.ErrInvalidContinuationToken) {
+				w.WriteHeader(http.StatusBadRequest)
+				writeJson(w, r, CreateErrorResponse("InvalidContinuationToken", "The'_ct' parameter is invalid."))
+				return
+			}
+
+			log.Ctx(r.Context()).Error().Msgf("Failed to list distinct values in DB: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		writeJson(w, r, ListResponse{Items: values, NextLink: getNextLink(r, ct)})
+	}
+}
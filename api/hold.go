@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ismrmrd/mrd-storage-server/core"
+	"github.com/rs/zerolog/log"
+)
+
+const maxLegalHoldReasonLength = 1024
+
+// Places a blob under legal hold, which keeps it from being deleted or expiring until the hold is released.
+// The request body gives the reason for the hold. Placing a hold on a blob that is already under legal hold
+// replaces the reason.
+func (handler *Handler) PlaceLegalHold(w http.ResponseWriter, r *http.Request) {
+	var request LegalHoldRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJson(w, r, CreateErrorResponse("InvalidBody", fmt.Sprintf("The request body must be a JSON object: %v", err)))
+		return
+	}
+
+	reason := strings.TrimSpace(request.Reason)
+	if reason == "" || len(reason) > maxLegalHoldReasonLength {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJson(w, r, CreateErrorResponse("InvalidReason", fmt.Sprintf("A reason of up to %d characters must be given for the legal hold.", maxLegalHoldReasonLength)))
+		return
+	}
+
+	handler.updateLegalHold(w, r, &core.BlobMetadataUpdate{LegalHoldReason: &reason})
+}
+
+// Releases the legal hold on a blob. If its expiration time has passed, it is removed
+// by the next garbage collection.
+func (handler *Handler) ReleaseLegalHold(w http.ResponseWriter, r *http.Request) {
+	handler.updateLegalHold(w, r, &core.BlobMetadataUpdate{ReleaseLegalHold: true})
+}
+
+func (handler *Handler) updateLegalHold(w http.ResponseWriter, r *http.Request, update *core.BlobMetadataUpdate) {
+	combinedId := chi.URLParam(r, "combined-id")
+	key, ok := getBlobSubjectAndIdFromCombinedId(combinedId)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	expectedVersions, ok := getExpectedVersions(r)
+	if !ok {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		writeJson(w, r, CreateErrorResponse("PreconditionFailed", "The blob metadata does not match the If-Match header."))
+		return
+	}
+
+	blobInfo, err := handler.db.UpdateBlobMetadata(r.Context(), key, update, expectedVersions, time.Now())
+	if err != nil {
+		if errors.Is(err, core.ErrRecordNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if errors.Is(err, core.ErrVersionMismatch) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			writeJson(w, r, CreateErrorResponse("PreconditionFailed", "The blob metadata does not match the If-Match header."))
+			return
+		}
+
+		log.Ctx(r.Context()).Error().Msgf("Failed to update legal hold: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	log.Ctx(r.Context()).Info().
+		Str("audit", "LegalHoldChanged").
+		Str("subject", key.Subject).
+		Str("id", key.Id.String()).
+		Bool("legalHold", blobInfo.LegalHoldReason != nil).
+		Msg("Changed the legal hold on a blob")
+
+	w.Header().Set("ETag", formatVersionETag(blobInfo.Version))
+	writeJson(w, r, CreateBlobInfo(r, blobInfo))
+}
@@ -0,0 +1,371 @@
+package api
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ismrmrd/mrd-storage-server/core"
+	"github.com/rs/zerolog/log"
+)
+
+// Fields of the metadata document that are derived from the blob and are not imported.
+// The legal hold of a blob is imported from `legalHoldReason`.
+var derivedManifestFields = map[string]bool{
+	"data":           true,
+	"size":           true,
+	"inlinedata":     true,
+	"inlineencoding": true,
+	"legalhold":      true,
+}
+
+// A blob listed in the manifest of an imported archive
+type manifestBlob struct {
+	key             core.BlobKey
+	tags            core.BlobTags
+	legalHoldReason *string
+	createdAt       time.Time
+	sha256          []byte
+	expired         bool
+	imported        *core.BlobInfo
+}
+
+// A context that keeps the values of its parent, like the request's logger, but is not canceled with it.
+// The same as context.WithoutCancel, which requires Go 1.21.
+type uncancelableContext struct {
+	parent context.Context
+}
+
+func (uncancelableContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (uncancelableContext) Done() <-chan struct{}               { return nil }
+func (uncancelableContext) Err() error                          { return nil }
+func (c uncancelableContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// An error that prevents an archive from being imported
+type importError struct {
+	status  int
+	code    string
+	message string
+}
+
+// Recreates the blobs in a tar or zip archive with the format produced by GetBlobArchive. Each blob keeps
+// its tags, creation time, and legal hold, but is given a new ID. Either all of the blobs are imported or none
+// of them are: the blobs stay hidden until all of them have been written, and are removed if the import fails.
+func (handler *Handler) ImportBlobArchive(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var archive archiveReader
+	switch mediaType {
+	case archiveContentTypes[ArchiveFormatTar]:
+		archive = tarArchiveReader{tar.NewReader(r.Body)}
+	case archiveContentTypes[ArchiveFormatZip]:
+		// The central directory of a zip file is at its end, so the archive cannot be read as a stream
+		f, err := os.CreateTemp("", "mrd-import-*.zip")
+		if err != nil {
+			log.Ctx(r.Context()).Error().Msgf("Failed to create temporary file: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		size, err := io.Copy(f, r.Body)
+		if err != nil {
+			log.Ctx(r.Context()).Error().Msgf("Failed to read request body: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		zipReader, err := zip.NewReader(f, size)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJson(w, r, CreateErrorResponse("InvalidArchive", fmt.Sprintf("The zip archive is invalid: %v", err)))
+			return
+		}
+
+		archive = newZipArchiveReader(zipReader)
+	default:
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		writeJson(w, r, CreateErrorResponse("UnsupportedMediaType", "The Content-Type of the request must be 'application/x-tar' or 'application/zip'."))
+		return
+	}
+
+	blobs, importErr := handler.importArchive(r.Context(), archive)
+	if importErr == nil {
+		importErr = handler.completeImportedBlobs(r.Context(), blobs)
+	}
+
+	if importErr != nil {
+		// the import may have failed because the client went away, which cancels the request's context
+		ctx := uncancelableContext{r.Context()}
+		for _, blob := range blobs {
+			if blob.imported != nil {
+				if err := core.DiscardImportedBlob(ctx, handler.db, handler.store, blob.imported); err != nil {
+					// garbage collection will clean up blobs that are still staged
+					log.Ctx(ctx).Error().Msgf("Failed to delete imported blob: %v", err)
+				}
+			}
+		}
+
+		w.WriteHeader(importErr.status)
+		if importErr.code != "" {
+			writeJson(w, r, CreateErrorResponse(importErr.code, importErr.message))
+		}
+		return
+	}
+
+	response := SearchResponse{Items: []map[string]interface{}{}}
+	for _, blob := range blobs {
+		if blob.imported != nil {
+			response.Items = append(response.Items, CreateBlobInfo(r, blob.imported))
+		}
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	writeJson(w, r, response)
+}
+
+// Imports the blobs of an archive. The returned blobs include the ones that were imported before any error occurred.
+func (handler *Handler) importArchive(ctx context.Context, archive archiveReader) ([]*manifestBlob, *importError) {
+	name, contents, err := archive.Next()
+	if err != nil || name != ArchiveManifestName {
+		return nil, &importError{http.StatusBadRequest, "InvalidArchive", fmt.Sprintf("The first file of the archive must be '%s'.", ArchiveManifestName)}
+	}
+
+	manifest := struct {
+		Items []map[string]interface{} `json:"items"`
+	}{}
+	err = json.NewDecoder(contents).Decode(&manifest)
+	contents.Close()
+	if err != nil {
+		return nil, &importError{http.StatusBadRequest, "InvalidManifest", fmt.Sprintf("The manifest is invalid: %v", err)}
+	}
+
+	now := time.Now()
+	blobs := make([]*manifestBlob, len(manifest.Items))
+	blobsByEntryName := make(map[string]*manifestBlob)
+	for i, item := range manifest.Items {
+		originalId, blob, err := parseManifestItem(item, now)
+		if err != nil {
+			return nil, &importError{http.StatusBadRequest, "InvalidManifest", fmt.Sprintf("Item %d of the manifest is invalid: %v", i, err)}
+		}
+
+		if !hasExpirationOption(&blob.tags) {
+			// the time-to-live counts from when the blob is imported, like for blobs stored before a policy was added
+			if policy := core.FindRetentionPolicy(handler.retentionPolicies, blob.key, &blob.tags); policy != nil {
+				timeToLive := policy.TimeToLive.String()
+				blob.tags.TimeToLive = &timeToLive
+			}
+		}
+
+		entryName := path.Join(archiveBlobDirectory, originalId.String())
+		if _, ok := blobsByEntryName[entryName]; ok {
+			return nil, &importError{http.StatusBadRequest, "InvalidManifest", fmt.Sprintf("The blob %v is listed more than once in the manifest.", originalId)}
+		}
+
+		blobs[i] = blob
+		blobsByEntryName[entryName] = blob
+	}
+
+	for {
+		name, contents, err := archive.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			return blobs, &importError{http.StatusBadRequest, "InvalidArchive", fmt.Sprintf("The archive is invalid: %v", err)}
+		}
+
+		importErr := handler.importArchiveEntry(ctx, blobsByEntryName[name], name, contents)
+		contents.Close()
+		if importErr != nil {
+			return blobs, importErr
+		}
+	}
+
+	for entryName, blob := range blobsByEntryName {
+		if blob.imported == nil && !blob.expired {
+			return blobs, &importError{http.StatusBadRequest, "InvalidArchive", fmt.Sprintf("The archive does not contain '%s'.", entryName)}
+		}
+	}
+
+	return blobs, nil
+}
+
+// Makes the imported blobs visible once all of them have been written
+func (handler *Handler) completeImportedBlobs(ctx context.Context, blobs []*manifestBlob) *importError {
+	for _, blob := range blobs {
+		if blob.imported == nil {
+			continue
+		}
+
+		completed, err := core.CompleteImportedBlob(ctx, handler.db, blob.imported, blob.createdAt)
+		if err != nil {
+			log.Ctx(ctx).Error().Msgf("Failed to complete imported blob: %v", err)
+			return &importError{status: http.StatusInternalServerError}
+		}
+
+		blob.imported = completed
+	}
+
+	return nil
+}
+
+func (handler *Handler) importArchiveEntry(ctx context.Context, blob *manifestBlob, name string, contents io.Reader) *importError {
+	if blob == nil {
+		return &importError{http.StatusBadRequest, "InvalidArchive", fmt.Sprintf("The file '%s' is not listed in the manifest.", name)}
+	}
+
+	if blob.imported != nil {
+		return &importError{http.StatusBadRequest, "InvalidArchive", fmt.Sprintf("The archive contains '%s' more than once.", name)}
+	}
+
+	if blob.expired {
+		return nil
+	}
+
+	imported, err := core.ImportBlob(ctx, handler.db, handler.store, blob.key, &blob.tags, blob.legalHoldReason, blob.sha256, contents)
+	if err != nil {
+		if errors.Is(err, core.ErrDigestMismatch) {
+			return &importError{http.StatusBadRequest, "DigestMismatch", fmt.Sprintf("The content of '%s' does not match the digest in the manifest.", name)}
+		}
+
+		log.Ctx(ctx).Error().Msgf("Failed to import blob: %v", err)
+		return &importError{status: http.StatusInternalServerError}
+	}
+
+	blob.imported = imported
+	return nil
+}
+
+// Converts an item of an archive manifest, which has the same format as the blob metadata response, to the
+// blob to create. Returns the original ID of the blob, which identifies its content in the archive.
+func parseManifestItem(item map[string]interface{}, now time.Time) (originalId uuid.UUID, blob *manifestBlob, err error) {
+	blob = &manifestBlob{key: core.BlobKey{Id: uuid.New()}}
+	hasSubject, hasCreatedAt := false, false
+
+	for field, value := range item {
+		if derivedManifestFields[strings.ToLower(field)] {
+			continue
+		}
+
+		values, err := manifestFieldValues(field, value)
+		if err != nil {
+			return originalId, nil, err
+		}
+
+		switch field {
+		case "location", "subject", "lastModified", "expires", "idleTtl", "contentType", "digest", "legalHoldReason":
+			if err := ValidateOnlyOneTag(field, values); err != nil {
+				return originalId, nil, err
+			}
+		}
+
+		switch {
+		case field == "location":
+			location, err := url.Parse(values[0])
+			if err != nil {
+				return originalId, nil, fmt.Errorf("the location '%s' is invalid", values[0])
+			}
+
+			originalKey, ok := getBlobSubjectAndIdFromCombinedId(path.Base(location.Path))
+			if !ok {
+				return originalId, nil, fmt.Errorf("the location '%s' is invalid", values[0])
+			}
+
+			originalId = originalKey.Id
+		case field == "subject":
+			if err := subjectTagValidator(field, values); err != nil {
+				return originalId, nil, err
+			}
+
+			blob.key.Subject = values[0]
+			hasSubject = true
+		case field == "lastModified":
+			if blob.createdAt, err = time.Parse(time.RFC3339Nano, values[0]); err != nil {
+				return originalId, nil, fmt.Errorf("the format of 'lastModified' is invalid")
+			}
+
+			hasCreatedAt = true
+		case field == "expires":
+			expiresAt, err := time.Parse(time.RFC3339Nano, values[0])
+			if err != nil {
+				return originalId, nil, fmt.Errorf("the format of 'expires' is invalid")
+			}
+
+			blob.tags.ExpiresAt = &expiresAt
+		case field == "idleTtl":
+			if err := idleTtlTagValidator(field, values); err != nil {
+				return originalId, nil, err
+			}
+
+			blob.tags.IdleTimeToLive = &values[0]
+		case field == "legalHoldReason":
+			reason := strings.TrimSpace(values[0])
+			if reason == "" || len(reason) > maxLegalHoldReasonLength {
+				return originalId, nil, fmt.Errorf("the 'legalHoldReason' must have up to %d characters", maxLegalHoldReasonLength)
+			}
+
+			blob.legalHoldReason = &reason
+		case field == "contentType":
+			blob.tags.ContentType = &values[0]
+		case field == "digest":
+			algorithm, encodedValue, _ := strings.Cut(values[0], "=")
+			if blob.sha256, err = base64.StdEncoding.DecodeString(encodedValue); err != nil || algorithm != DigestAlgorithmSha256 {
+				return originalId, nil, fmt.Errorf("the digest '%s' is invalid", values[0])
+			}
+		case strings.HasPrefix(field, "_"):
+			return originalId, nil, fmt.Errorf("'%s' is not a valid tag name", field)
+		default:
+			if err := ValidateAndStoreTag(&blob.tags, field, values); err != nil {
+				return originalId, nil, err
+			}
+		}
+	}
+
+	if originalId == uuid.Nil || !hasSubject || !hasCreatedAt {
+		return originalId, nil, errors.New("'location', 'subject', and 'lastModified' are required")
+	}
+
+	// blobs under legal hold are exported even after their expiration time has passed
+	blob.expired = blob.tags.ExpiresAt != nil && !blob.tags.ExpiresAt.After(now) && blob.legalHoldReason == nil
+
+	return originalId, blob, nil
+}
+
+// Returns the values of a manifest field, which can be a string or an array of strings
+func manifestFieldValues(field string, value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		values := make([]string, len(v))
+		for i, element := range v {
+			s, ok := element.(string)
+			if !ok {
+				return nil, fmt.Errorf("the values of '%s' must be strings", field)
+			}
+
+			values[i] = s
+		}
+
+		return values, nil
+	}
+
+	return nil, fmt.Errorf("the value of '%s' must be a string or an array of strings", field)
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/ismrmrd/mrd-storage-server/core"
+	"github.com/ismrmrd/mrd-storage-server/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure that imported blobs stay staged until the whole archive has been read, and that they are
+// removed when the import fails, even if the request's context has been canceled.
+func TestFailedImportRemovesStagedBlobs(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockMetadataDatabase := mocks.NewMockMetadataDatabase(mockCtrl)
+	mockBlobStore := mocks.NewMockBlobStore(mockCtrl)
+
+	ids := []uuid.UUID{uuid.New(), uuid.New()}
+	items := make([]map[string]interface{}, len(ids))
+	for i, id := range ids {
+		items[i] = map[string]interface{}{
+			"location":     fmt.Sprintf("http://localhost/v1/blobs/%s-s", id),
+			"subject":      "s",
+			"lastModified": "2021-11-05T10:57:16.605Z",
+		}
+	}
+
+	manifest, err := json.Marshal(map[string]interface{}{"items": items})
+	require.Nil(t, err)
+
+	// the second blob is missing from the archive
+	archive := &bytes.Buffer{}
+	writer := tar.NewWriter(archive)
+	for _, entry := range []struct{ name, contents string }{
+		{ArchiveManifestName, string(manifest)},
+		{ArchiveBlobEntryName(core.BlobKey{Id: ids[0]}), "first"},
+	} {
+		require.Nil(t, writer.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: entry.name, Size: int64(len(entry.contents)), Mode: 0644}))
+		_, err := writer.Write([]byte(entry.contents))
+		require.Nil(t, err)
+	}
+	require.Nil(t, writer.Close())
+
+	var importedKey core.BlobKey
+	mockMetadataDatabase.EXPECT().
+		StageBlobMetadata(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, key core.BlobKey, tags *core.BlobTags) (*core.BlobInfo, error) {
+			importedKey = key
+			return &core.BlobInfo{Key: key, Tags: *tags}, nil
+		})
+	mockBlobStore.EXPECT().
+		SaveBlob(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, contents io.Reader, key core.BlobKey) error {
+			_, err := io.Copy(io.Discard, contents)
+			return err
+		})
+
+	// the blob was never completed, so it is still staged when it is removed
+	mockMetadataDatabase.EXPECT().
+		StageBlobMetadataForDeletion(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, key core.BlobKey) error {
+			assert.Nil(t, ctx.Err())
+			return core.ErrRecordNotFound
+		})
+	mockBlobStore.EXPECT().
+		DeleteBlob(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, key core.BlobKey) error {
+			assert.Nil(t, ctx.Err())
+			assert.Equal(t, importedKey, key)
+			return nil
+		})
+	mockMetadataDatabase.EXPECT().
+		DeleteBlobMetadata(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, key core.BlobKey) error {
+			assert.Nil(t, ctx.Err())
+			return nil
+		})
+
+	router := BuildRouter(mockMetadataDatabase, mockBlobStore, nil, false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("POST", "/v1/blobs/archive", archive).WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-tar")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ismrmrd/mrd-storage-server/core"
+)
+
+const (
+	InlineEncodingJson   = "json"
+	InlineEncodingBase64 = "base64"
+
+	defaultMaxInlineSize = 64 * 1024
+	maxMaxInlineSize     = 1024 * 1024
+
+	// The maximum number of blobs read from the store at the same time for a request
+	inlineReadConcurrency = 8
+)
+
+var (
+	byteSizeRegex     = regexp.MustCompile(`^(?i)([0-9]+)\s*(b|kb|kib|mb|mib)?$`)
+	byteSizeMultiples = map[string]int64{"": 1, "b": 1, "kb": 1000, "kib": 1024, "mb": 1000 * 1000, "mib": 1024 * 1024}
+)
+
+// Parses the `_include` and `_maxInline` parameters. Returns the maximum size of the blobs whose content
+// should be included in the response, or 0 if no content should be included.
+func getInlineOptions(w http.ResponseWriter, r *http.Request, options url.Values) (maxInlineSize int64, ok bool) {
+	includeData := false
+	for _, v := range options["_include"] {
+		for _, include := range strings.Split(v, ",") {
+			if include != "data" {
+				w.WriteHeader(http.StatusBadRequest)
+				writeJson(w, r, CreateErrorResponse("InvalidParameter", fmt.Sprintf("The value '%s' of the '_include' parameter is not supported. The only supported value is 'data'.", include)))
+				return 0, false
+			}
+
+			includeData = true
+		}
+	}
+
+	maxInlineStrings, hasMaxInline := options["_maxinline"]
+	if !includeData {
+		if hasMaxInline {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJson(w, r, CreateErrorResponse("InvalidParameter", "The '_maxInline' parameter can only be used with '_include=data'."))
+			return 0, false
+		}
+
+		return 0, true
+	}
+
+	if !hasMaxInline {
+		return defaultMaxInlineSize, true
+	}
+
+	if len(maxInlineStrings) > 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJson(w, r, CreateErrorResponse("InvalidParameter", "The '_maxInline' parameter was specified multiple times in the URL."))
+		return 0, false
+	}
+
+	maxInlineSize, err := parseByteSize(maxInlineStrings[0])
+	if err != nil || maxInlineSize <= 0 || maxInlineSize > maxMaxInlineSize {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJson(w, r, CreateErrorResponse("InvalidParameter", "The '_maxInline' parameter must be a size between 1 byte and 1MiB, such as '64KiB'."))
+		return 0, false
+	}
+
+	return maxInlineSize, true
+}
+
+// Parses a number of bytes with an optional unit, e.g. 1024, 64KiB, or 1MB
+func parseByteSize(s string) (int64, error) {
+	match := byteSizeRegex.FindStringSubmatch(strings.TrimSpace(s))
+	if match == nil {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+
+	value, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return value * byteSizeMultiples[strings.ToLower(match[2])], nil
+}
+
+// Adds the content of each blob that is no larger than maxInlineSize to the corresponding response entry.
+// Content with a JSON content type is embedded as is, and other content is base64-encoded.
+// Blobs are read concurrently, with a bounded number of reads in progress at the same time.
+func (handler *Handler) inlineBlobData(ctx context.Context, blobs []*core.BlobInfo, entries []map[string]interface{}, maxInlineSize int64) error {
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+
+	for i := 0; i < inlineReadConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				if err := handler.inlineSingleBlobData(ctx, blobs[index], entries[index]); err != nil {
+					errOnce.Do(func() { firstErr = err })
+				}
+			}
+		}()
+	}
+
+	for i, blob := range blobs {
+		// the size is not known for blobs created by older versions of the server
+		if blob != nil && blob.Size != nil && *blob.Size <= maxInlineSize {
+			indexes <- i
+		}
+	}
+
+	close(indexes)
+	wg.Wait()
+
+	return firstErr
+}
+
+func (handler *Handler) inlineSingleBlobData(ctx context.Context, blob *core.BlobInfo, entry map[string]interface{}) error {
+	buf := bytes.Buffer{}
+	buf.Grow(int(*blob.Size))
+
+	if err := handler.store.ReadBlob(ctx, &buf, blob.Key); err != nil {
+		if errors.Is(err, core.ErrBlobNotFound) {
+			// the blob was deleted after it was found
+			return nil
+		}
+
+		return err
+	}
+
+	handler.refreshIdleExpiration(ctx, blob)
+
+	if isJsonContentType(blob.Tags.ContentType) && json.Valid(buf.Bytes()) {
+		entry["inlineData"] = json.RawMessage(buf.Bytes())
+		entry["inlineEncoding"] = InlineEncodingJson
+	} else {
+		entry["inlineData"] = base64.StdEncoding.EncodeToString(buf.Bytes())
+		entry["inlineEncoding"] = InlineEncodingBase64
+	}
+
+	return nil
+}
+
+func isJsonContentType(contentType *string) bool {
+	if contentType == nil {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(*contentType)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ismrmrd/mrd-storage-server/core"
+	"github.com/rs/zerolog/log"
+)
+
+func (handler *Handler) PurgeSubject(w http.ResponseWriter, r *http.Request) {
+
+	query := normalizeQueryMapToLowercaseKeys(r.URL.Query())
+
+	subjectStrings, ok := query["subject"]
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJson(w, r, CreateErrorResponse("InvalidQuery", "'subject' query parameter is mandatory."))
+		return
+	}
+
+	if err := subjectTagValidator("subject", subjectStrings); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJson(w, r, CreateErrorResponse("InvalidSubject", err.Error()))
+		return
+	}
+
+	subject := subjectStrings[0]
+	if subject == NullSubject {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJson(w, r, CreateErrorResponse("InvalidSubject", "Blobs that are not associated with a subject cannot be purged."))
+		return
+	}
+
+	record, err := core.PurgeSubject(r.Context(), handler.db, handler.store, subject)
+	if err != nil {
+		if errors.Is(err, core.ErrLegalHold) {
+			w.WriteHeader(http.StatusConflict)
+			writeJson(w, r, CreateErrorResponse("LegalHold", "The subject has blobs under legal hold and cannot be purged."))
+			return
+		}
+
+		log.Ctx(r.Context()).Error().Msgf("Failed to purge subject: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJson(w, r, CreateErrorResponse("PurgeIncomplete", fmt.Sprintf("The purge failed after erasing %d blobs. Retry the request to erase the rest.", len(record.ErasedBlobs))))
+		return
+	}
+
+	writeJson(w, r, CreatePurgeSubjectResponse(record))
+}
+
+func CreatePurgeSubjectResponse(record *core.SubjectPurgeRecord) PurgeSubjectResponse {
+	erasedBlobIds := make([]string, len(record.ErasedBlobs))
+	for i, key := range record.ErasedBlobs {
+		erasedBlobIds[i] = key.Id.String()
+	}
+
+	return PurgeSubjectResponse{
+		Subject:       record.Subject,
+		ErasedBlobIds: erasedBlobIds,
+		StartedAt:     record.StartedAt.UTC().Format(time.RFC3339Nano),
+		CompletedAt:   record.CompletedAt.UTC().Format(time.RFC3339Nano),
+	}
+}
@@ -1,11 +1,14 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/ismrmrd/mrd-storage-server/core"
 	"github.com/rs/zerolog/log"
 	"github.com/xorcare/pointer"
@@ -35,22 +38,69 @@ func (handler *Handler) MakeBlobEndpoint(responder Responder, grace time.Duratio
 			return
 		}
 
-		responder(w, r, blobInfo)
+		handler.respondToBlobRead(w, r, blobInfo, responder)
+	}
+}
+
+// Writes the response for a blob that is being read, then pushes the blob's idle expiration forward if the
+// response was successful. Failed reads and 304 Not Modified responses do not count as reads.
+func (handler *Handler) respondToBlobRead(w http.ResponseWriter, r *http.Request, blobInfo *core.BlobInfo, responder Responder) {
+	ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+	responder(ww, r, blobInfo)
+
+	if status := ww.Status(); status == http.StatusOK || status == http.StatusPartialContent {
+		handler.refreshIdleExpiration(r.Context(), blobInfo)
+	}
+}
+
+// Pushes the expiration of a blob with an idle time-to-live forward because it has been read.
+// Failures are logged but do not fail the read.
+func (handler *Handler) refreshIdleExpiration(ctx context.Context, blobInfo *core.BlobInfo) {
+	if err := core.RefreshIdleExpiration(ctx, handler.db, blobInfo, time.Now()); err != nil {
+		log.Ctx(ctx).Warn().Msgf("Failed to extend blob expiration: %v", err)
 	}
 }
 
 func (handler *Handler) BlobMetadataResponse(w http.ResponseWriter, r *http.Request, blobInfo *core.BlobInfo) {
-	writeJson(w, r, CreateBlobInfo(r, blobInfo))
+	fields, ok := getFieldProjection(w, r, normalizeQueryMapToLowercaseKeys(r.URL.Query())["_fields"])
+	if !ok {
+		return
+	}
+
+	w.Header().Set("ETag", formatVersionETag(blobInfo.Version))
+	writeJson(w, r, projectBlobInfo(CreateBlobInfo(r, blobInfo), fields))
+}
+
+// Blob content never changes, so responses for a given blob ID can be cached. But the tags and expiration
+// returned as headers can be changed, so caches must revalidate their copy before using it. Revalidation
+// is cheap because a 304 response does not read the blob from storage.
+func (handler *Handler) CacheableBlobDataResponse(w http.ResponseWriter, r *http.Request, blobInfo *core.BlobInfo) {
+	w.Header().Set("Cache-Control", "private, no-cache")
+	handler.BlobDataResponse(w, r, blobInfo)
 }
 
 func (handler *Handler) BlobDataResponse(w http.ResponseWriter, r *http.Request, blobInfo *core.BlobInfo) {
 
 	writeTagsAsHeaders(w, blobInfo)
 
-	if err := handler.store.ReadBlob(r.Context(), w, blobInfo.Key); err != nil {
+	// Check this before going to the blob store so that
+	// revalidating a cached blob does not read it from storage
+	if isNotModified(r, blobInfo) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	blob, err := handler.store.OpenBlob(r.Context(), blobInfo.Key)
+	if err != nil {
 		log.Ctx(r.Context()).Error().Msgf("Failed to read blob from storage: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
+
+	defer blob.Close()
+
+	// ServeContent takes care of HEAD requests as well as the Range and If-Range headers
+	http.ServeContent(w, r, "", blobInfo.CreatedAt, blob)
 }
 
 func writeTagsAsHeaders(w http.ResponseWriter, blobInfo *core.BlobInfo) {
@@ -58,10 +108,14 @@ func writeTagsAsHeaders(w http.ResponseWriter, blobInfo *core.BlobInfo) {
 		blobInfo.Tags.ContentType = pointer.String("application/octet-stream")
 	}
 	if blobInfo.ExpiresAt != nil {
-		w.Header().Add("Expires", blobInfo.ExpiresAt.Format(http.TimeFormat))
+		w.Header().Add("Expires", blobInfo.ExpiresAt.UTC().Format(http.TimeFormat))
 	}
 	w.Header().Add("Content-Type", *blobInfo.Tags.ContentType)
-	w.Header().Add("Last-Modified", blobInfo.CreatedAt.Format(http.TimeFormat))
+	w.Header().Add("Last-Modified", blobInfo.CreatedAt.UTC().Format(http.TimeFormat))
+	if blobInfo.Sha256 != nil {
+		w.Header().Set("ETag", formatSha256ETag(blobInfo.Sha256))
+		w.Header().Set("Digest", formatSha256Digest(blobInfo.Sha256))
+	}
 
 	addSystemTagIfSet(w, "Device", blobInfo.Tags.Device)
 	addSystemTagIfSet(w, "Name", blobInfo.Tags.Name)
@@ -77,6 +131,42 @@ func writeTagsAsHeaders(w http.ResponseWriter, blobInfo *core.BlobInfo) {
 	}
 }
 
+// Evaluates the If-None-Match and If-Modified-Since request headers as described in RFC 7232 section 6.
+// If-Modified-Since is ignored when If-None-Match is present.
+func isNotModified(r *http.Request, blobInfo *core.BlobInfo) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if ifNoneMatch := r.Header.Values("If-None-Match"); len(ifNoneMatch) > 0 {
+		for _, candidate := range strings.Split(strings.Join(ifNoneMatch, ","), ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" {
+				return true
+			}
+
+			// If-None-Match uses the weak comparison function
+			if blobInfo.Sha256 != nil && strings.TrimPrefix(candidate, "W/") == formatSha256ETag(blobInfo.Sha256) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if ifModifiedSince := r.Header.Get("If-Modified-Since"); ifModifiedSince != "" {
+		since, err := http.ParseTime(ifModifiedSince)
+		if err != nil {
+			return false
+		}
+
+		// Last-Modified only has a resolution of seconds
+		return !blobInfo.CreatedAt.Truncate(time.Second).After(since)
+	}
+
+	return false
+}
+
 func addSystemTagIfSet(w http.ResponseWriter, tagName string, tagValue *string) {
 	if tagValue != nil {
 		w.Header().Add(TagHeaderName(tagName), *tagValue)
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/ismrmrd/mrd-storage-server/core"
+	"github.com/ismrmrd/mrd-storage-server/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotModifiedDoesNotReadFromStorage(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockMetadataDatabase := mocks.NewMockMetadataDatabase(mockCtrl)
+	mockBlobStore := mocks.NewMockBlobStore(mockCtrl)
+
+	contentType := "text/plain"
+	hash := sha256.Sum256([]byte("content"))
+	blobInfo := &core.BlobInfo{
+		Key:       core.BlobKey{Subject: "a", Id: uuid.New()},
+		Tags:      core.BlobTags{ContentType: &contentType, CustomTags: map[string][]string{}},
+		CreatedAt: time.Now().Add(-time.Hour),
+		Sha256:    hash[:],
+	}
+
+	mockMetadataDatabase.EXPECT().
+		GetBlobMetadata(gomock.Any(), blobInfo.Key, gomock.Any()).
+		Return(blobInfo, nil).
+		Times(2)
+
+	router := BuildRouter(mockMetadataDatabase, mockBlobStore, nil, false)
+
+	req := httptest.NewRequest("GET", "/v1/blobs/"+getBlobCombinedId(blobInfo.Key)+"/data", nil)
+	req.Header.Set("If-None-Match", formatSha256ETag(blobInfo.Sha256))
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusNotModified, resp.Result().StatusCode)
+	assert.Equal(t, formatSha256ETag(blobInfo.Sha256), resp.Result().Header.Get("ETag"))
+	assert.Equal(t, "private, no-cache", resp.Result().Header.Get("Cache-Control"))
+
+	req = httptest.NewRequest("GET", "/v1/blobs/"+getBlobCombinedId(blobInfo.Key)+"/data", nil)
+	req.Header.Set("If-Modified-Since", time.Now().UTC().Format(http.TimeFormat))
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusNotModified, resp.Result().StatusCode)
+}
+
+// Ensure that only successful reads push the expiration of a blob with an idle time-to-live forward.
+func TestFailedReadDoesNotRefreshIdleExpiration(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockMetadataDatabase := mocks.NewMockMetadataDatabase(mockCtrl)
+	mockBlobStore := mocks.NewMockBlobStore(mockCtrl)
+
+	idleTtl := time.Hour
+	expiresAt := time.Now().Add(10 * time.Minute)
+	blobInfo := &core.BlobInfo{
+		Key:            core.BlobKey{Subject: "a", Id: uuid.New()},
+		CreatedAt:      time.Now().Add(-time.Hour),
+		ExpiresAt:      &expiresAt,
+		IdleTimeToLive: &idleTtl,
+	}
+
+	mockMetadataDatabase.EXPECT().
+		GetBlobMetadata(gomock.Any(), blobInfo.Key, gomock.Any()).
+		Return(blobInfo, nil)
+
+	mockBlobStore.EXPECT().
+		OpenBlob(gomock.Any(), blobInfo.Key).
+		Return(nil, errors.New("storage unavailable"))
+
+	router := BuildRouter(mockMetadataDatabase, mockBlobStore, nil, false)
+
+	req := httptest.NewRequest("GET", "/v1/blobs/"+getBlobCombinedId(blobInfo.Key)+"/data", nil)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusInternalServerError, resp.Result().StatusCode)
+}
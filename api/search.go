@@ -1,136 +1,589 @@
 package api
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ismrmrd/mrd-storage-server/core"
 	"github.com/rs/zerolog/log"
 )
 
+const (
+	NdjsonContentType = "application/x-ndjson"
+
+	// The number of results read from the database at a time when streaming search results
+	streamPageSize = 500
+
+	// The maximum number of results in a page of search results with inlined blob content, whether paged
+	// or streamed. Each result can hold up to maxMaxInlineSize bytes, so pages are kept small to bound memory use.
+	inlinePageSize = 2 * inlineReadConcurrency
+)
+
+var supportedFilterOperators = map[core.FilterOperator]bool{
+	core.FilterOperatorEqual:              true,
+	core.FilterOperatorNotEqual:           true,
+	core.FilterOperatorGreaterThan:        true,
+	core.FilterOperatorGreaterThanOrEqual: true,
+	core.FilterOperatorLessThan:           true,
+	core.FilterOperatorLessThanOrEqual:    true,
+	core.FilterOperatorPrefix:             true,
+	core.FilterOperatorIn:                 true,
+	core.FilterOperatorExists:             true,
+	core.FilterOperatorAbsent:             true,
+}
+
 func (handler *Handler) SearchBlobs(w http.ResponseWriter, r *http.Request) {
 
-	query, at, ct, pageSize, ok := getSearchParameters(w, r)
+	filter, options, ct, pageSize, ok := getSearchParameters(w, r, "_sort", "_fields", "_include", "_maxinline")
+	if !ok {
+		return
+	}
+
+	sort, ok := getSortOrder(w, r, options)
 	if !ok {
 		return
 	}
 
-	results, ct, err := handler.db.SearchBlobMetadata(r.Context(), query, at, ct, pageSize, time.Now())
+	fields, ok := getFieldProjection(w, r, options["_fields"])
+	if !ok {
+		return
+	}
 
-	if err != nil {
-		if errors.Is(err, core.ErrInvalidContinuationToken) {
+	maxInlineSize, ok := getInlineOptions(w, r, options)
+	if !ok {
+		return
+	}
+
+	if acceptsMediaType(r, NdjsonContentType) {
+		// all results are streamed, so a page size would be ignored
+		if _, hasLimit := normalizeQueryMapToLowercaseKeys(r.URL.Query())["_limit"]; hasLimit {
 			w.WriteHeader(http.StatusBadRequest)
-			writeJson(w, r, CreateErrorResponse("InvalidContinuationToken", "The'_ct' parameter is invalid."))
+			writeJson(w, r, CreateErrorResponse("InvalidParameter", "The '_limit' parameter cannot be used when requesting newline-delimited JSON, which returns all results."))
 			return
 		}
 
-		log.Ctx(r.Context()).Error().Msgf("Failed to search blobs in DB: %v", err)
+		handler.streamSearchResults(w, r, filter, sort, ct, fields, maxInlineSize)
+		return
+	}
+
+	if maxInlineSize > 0 && pageSize > inlinePageSize {
+		pageSize = inlinePageSize
+	}
+
+	results, ct, err := handler.db.SearchBlobMetadata(r.Context(), filter, sort, ct, pageSize, time.Now())
+
+	if err != nil {
+		writeSearchError(w, r, err)
+		return
+	}
+
+	responseEntries, err := handler.createSearchResponseEntries(r, results, fields, maxInlineSize)
+	if err != nil {
+		log.Ctx(r.Context()).Error().Msgf("Failed to read blob from storage: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeJson(w, r, SearchResponse{Items: responseEntries, NextLink: getNextLink(r, ct)})
+}
+
+// Writes all search results as newline-delimited JSON, starting at the given continuation token.
+// Results are read from the database a page at a time and each page is flushed to the client before
+// the next one is read, so a slow client slows down the reads instead of causing results to be buffered.
+func (handler *Handler) streamSearchResults(w http.ResponseWriter, r *http.Request, filter *core.SearchFilter, sort core.SortOrder, ct *core.ContinutationToken, fields map[string]bool, maxInlineSize int64) {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	flusher, _ := w.(http.Flusher)
+
+	// use the same time for all pages so that the results are consistent
+	now := time.Now()
+
+	pageSize := streamPageSize
+	if maxInlineSize > 0 {
+		pageSize = inlinePageSize
+	}
+
+	for firstPage := true; ; firstPage = false {
+		results, nextCt, err := handler.db.SearchBlobMetadata(r.Context(), filter, sort, ct, pageSize, now)
+		if err != nil {
+			if firstPage {
+				writeSearchError(w, r, err)
+				return
+			}
+
+			abortStreamedResponse(r, "Failed to search blobs in DB: %v", err)
+			return
+		}
+
+		entries, err := handler.createSearchResponseEntries(r, results, fields, maxInlineSize)
+		if err != nil {
+			if firstPage {
+				log.Ctx(r.Context()).Error().Msgf("Failed to read blob from storage: %v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			abortStreamedResponse(r, "Failed to read blob from storage: %v", err)
+			return
+		}
+
+		if firstPage {
+			w.Header().Set("Content-Type", NdjsonContentType)
+			w.WriteHeader(http.StatusOK)
+		}
+
+		for _, entry := range entries {
+			if err := encoder.Encode(entry); err != nil {
+				// the client went away
+				return
+			}
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if nextCt == nil {
+			return
+		}
+
+		ct = nextCt
+	}
+}
+
+// Called when an error occurs after the status code of a streamed response has been sent.
+// Aborts the response so that the client can tell that the results are incomplete.
+func abortStreamedResponse(r *http.Request, format string, err error) {
+	if r.Context().Err() != nil {
+		// the client went away
+		return
+	}
+
+	log.Ctx(r.Context()).Error().Msgf(format, err)
+	panic(http.ErrAbortHandler)
+}
+
+// Creates the response entries for a page of search results. If maxInlineSize is greater than zero,
+// the content of blobs no larger than that is included.
+func (handler *Handler) createSearchResponseEntries(r *http.Request, results []core.BlobInfo, fields map[string]bool, maxInlineSize int64) ([]map[string]interface{}, error) {
+	entries := make([]map[string]interface{}, len(results))
+	blobs := make([]*core.BlobInfo, len(results))
+	for i := range results {
+		blobs[i] = &results[i]
+		entries[i] = projectBlobInfo(CreateBlobInfo(r, blobs[i]), fields)
+	}
+
+	if maxInlineSize > 0 {
+		if err := handler.inlineBlobData(r.Context(), blobs, entries, maxInlineSize); err != nil {
+			return nil, err
+		}
+	}
+
+	return entries, nil
+}
+
+func writeSearchError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, core.ErrInvalidContinuationToken) {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJson(w, r, CreateErrorResponse("InvalidContinuationToken", "The'_ct' parameter is invalid."))
+		return
+	}
+
+	log.Ctx(r.Context()).Error().Msgf("Failed to search blobs in DB: %v", err)
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+// Returns the link to the next page of results for the given continuation token, or an empty string if ct is nil
+func getNextLink(r *http.Request, ct *core.ContinutationToken) string {
+	if ct == nil {
+		return ""
+	}
+
+	nextQuery := r.URL.Query()
+	nextQuery.Set("_ct", string(*ct))
+
+	url := getBaseUri(r)
+	url.Path = r.URL.Path
+	url.RawQuery = nextQuery.Encode()
+	return url.String()
+}
+
+func (handler *Handler) CountBlobs(w http.ResponseWriter, r *http.Request) {
+
+	filter, _, _, _, ok := getSearchParameters(w, r)
+	if !ok {
+		return
+	}
+
+	count, err := handler.db.CountBlobMetadata(r.Context(), filter, time.Now())
+	if err != nil {
+		log.Ctx(r.Context()).Error().Msgf("Failed to count blobs in DB: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	responseEntries := make([]map[string]interface{}, len(results))
+	writeJson(w, r, CountResponse{Count: count})
+}
+
+func (handler *Handler) GetBlobFacets(w http.ResponseWriter, r *http.Request) {
 
-	for i, res := range results {
-		responseEntries[i] = CreateBlobInfo(r, &res)
+	filter, options, _, _, ok := getSearchParameters(w, r, "_facet")
+	if !ok {
+		return
 	}
 
-	searchResponse := SearchResponse{Items: responseEntries}
+	tagNames := make([]string, 0, len(options["_facet"]))
+	for _, tagName := range options["_facet"] {
+		tagName = strings.ToLower(tagName)
+		if err := ValidateTagName(tagName, nil); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJson(w, r, CreateErrorResponse("InvalidParameter", fmt.Sprintf("The '_facet' parameter is invalid: %v", err)))
+			return
+		}
+
+		tagNames = append(tagNames, tagName)
+	}
+
+	if len(tagNames) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJson(w, r, CreateErrorResponse("InvalidParameter", "At least one '_facet' parameter must be specified."))
+		return
+	}
 
-	if ct != nil {
-		nextQuery := r.URL.Query()
-		nextQuery.Set("_ct", string(*ct))
+	facets, err := handler.db.GetBlobMetadataFacets(r.Context(), filter, tagNames, time.Now())
+	if err != nil {
+		log.Ctx(r.Context()).Error().Msgf("Failed to get blob facets from DB: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response := FacetsResponse{Facets: make(map[string][]FacetValue, len(facets))}
+	for tagName, values := range facets {
+		responseValues := make([]FacetValue, len(values))
+		for i, v := range values {
+			responseValues[i] = FacetValue{Value: v.Value, Count: v.Count}
+		}
 
-		url := getBaseUri(r)
-		url.Path = r.URL.Path
-		url.RawQuery = nextQuery.Encode()
-		searchResponse.NextLink = url.String()
+		response.Facets[tagName] = responseValues
 	}
 
-	writeJson(w, r, searchResponse)
+	writeJson(w, r, response)
 }
 
 func (handler *Handler) GetLatestBlobData(w http.ResponseWriter, r *http.Request) {
 
-	query, at, _, _, ok := getSearchParameters(w, r)
+	latestBlobInfo, _, ok := handler.findLatestBlob(w, r)
 	if !ok {
 		return
 	}
 
-	results, _, err := handler.db.SearchBlobMetadata(r.Context(), query, at, nil, 1, time.Now())
+	handler.respondToBlobRead(w, r, latestBlobInfo, handler.BlobDataResponse)
+}
+
+// Returns the metadata of the latest blob matching the query, or with the `_redirect` option,
+// redirects to the blob's data.
+func (handler *Handler) GetLatestBlobMetadata(w http.ResponseWriter, r *http.Request) {
+
+	latestBlobInfo, options, ok := handler.findLatestBlob(w, r, "_redirect", "_fields")
+	if !ok {
+		return
+	}
+
+	redirect, ok := getFlagOption(w, r, options, "_redirect")
+	if !ok {
+		return
+	}
+
+	if redirect {
+		http.Redirect(w, r, getDataUri(r, latestBlobInfo.Key), http.StatusSeeOther)
+		return
+	}
+
+	handler.respondToBlobRead(w, r, latestBlobInfo, handler.BlobMetadataResponse)
+}
+
+// Searches for the latest blob matching the query and sets the Location header to its URI.
+// Writes an error response and returns ok=false if there is no such blob.
+func (handler *Handler) findLatestBlob(w http.ResponseWriter, r *http.Request, supportedOptions ...string) (latestBlobInfo *core.BlobInfo, options url.Values, ok bool) {
+
+	filter, options, _, _, ok := getSearchParameters(w, r, supportedOptions...)
+	if !ok {
+		return nil, nil, false
+	}
+
+	results, _, err := handler.db.SearchBlobMetadata(r.Context(), filter, core.SortOrder{}, nil, 1, time.Now())
 
 	if err != nil {
 		log.Ctx(r.Context()).Error().Msgf("Failed to search blobs in DB: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return nil, nil, false
 	}
 
 	if len(results) == 0 {
 		w.WriteHeader(http.StatusNotFound)
 		writeJson(w, r, CreateErrorResponse("EmptyResults", "The search returned no results."))
-		return
+		return nil, nil, false
 	}
 
-	latestBlobInfo := results[0]
+	latestBlobInfo = &results[0]
 
 	w.Header().Add("Location", getBlobUri(r, latestBlobInfo.Key))
 
-	handler.BlobDataResponse(w, r, &latestBlobInfo)
+	return latestBlobInfo, options, true
 }
 
-func getSearchParameters(w http.ResponseWriter, r *http.Request) (tags url.Values, at *time.Time, ct *core.ContinutationToken, pageSize int, ok bool) {
-	tags = normalizeQueryMapToLowercaseKeys(r.URL.Query())
+// Parses the search filter and paging parameters from the query string. Other parameters starting with an
+// underscore are returned in options for the caller to interpret, and must be among the given lowercase
+// supportedOptions so that a misspelled or misplaced option is not silently ignored.
+func getSearchParameters(w http.ResponseWriter, r *http.Request, supportedOptions ...string) (filter *core.SearchFilter, options url.Values, ct *core.ContinutationToken, pageSize int, ok bool) {
+	filter, options, ct, pageSize, err := parseSearchParameters(normalizeQueryMapToLowercaseKeys(r.URL.Query()))
+	if err == nil {
+		err = checkSupportedOptions(options, supportedOptions)
+	}
 
-	if !tags.Has("subject") {
+	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		writeJson(w, r, CreateErrorResponse("InvalidQuery", "'subject' query parameter is mandatory. To search for blobs where not associated with a subject, you can specify 'subject=$null'"))
+		writeJson(w, r, CreateErrorResponse(err.code, err.message))
+		return
+	}
+
+	ok = true
+	return
+}
+
+func checkSupportedOptions(options url.Values, supportedOptions []string) *searchParameterError {
+	for option := range options {
+		supported := false
+		for _, supportedOption := range supportedOptions {
+			if option == supportedOption {
+				supported = true
+				break
+			}
+		}
+
+		if !supported {
+			return &searchParameterError{"InvalidParameter", fmt.Sprintf("The '%s' parameter is not supported by this endpoint.", option)}
+		}
+	}
+
+	return nil
+}
+
+// An invalid search parameter
+type searchParameterError struct {
+	code    string
+	message string
+}
+
+// Parses search parameters given as a map with lowercase keys. The map is modified.
+func parseSearchParameters(query url.Values) (filter *core.SearchFilter, options url.Values, ct *core.ContinutationToken, pageSize int, err *searchParameterError) {
+	filter = &core.SearchFilter{}
+	options = make(url.Values)
+
+	if !query.Has("subject") {
+		err = &searchParameterError{"InvalidQuery", "'subject' query parameter is mandatory. To search for blobs where not associated with a subject, you can specify 'subject=$null'"}
 		return
 	}
 
-	if timeStrings, hasAt := tags["_at"]; hasAt {
+	for _, timeParameter := range []struct {
+		name  string
+		field **time.Time
+	}{
+		{"_at", &filter.At},
+		{"_createdafter", &filter.CreatedAfter},
+		{"_createdbefore", &filter.CreatedBefore},
+	} {
+		timeStrings, hasParameter := query[timeParameter.name]
+		if !hasParameter {
+			continue
+		}
+
 		if len(timeStrings) > 1 {
-			w.WriteHeader(http.StatusBadRequest)
-			writeJson(w, r, CreateErrorResponse("InvalidParameter", "The '_at' parameter was specified multiple times in the URL."))
+			err = &searchParameterError{"InvalidParameter", fmt.Sprintf("The '%s' parameter was specified multiple times in the URL.", timeParameter.name)}
 			return
 		}
 
-		parsedTime, atErr := time.Parse(time.RFC3339Nano, timeStrings[0])
-		if atErr != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			writeJson(w, r, CreateErrorResponse("InvalidParameter", "The format of the '_at' parameter is invalid"))
+		parsedTime, timeErr := time.Parse(time.RFC3339Nano, timeStrings[0])
+		if timeErr != nil {
+			err = &searchParameterError{"InvalidParameter", fmt.Sprintf("The format of the '%s' parameter is invalid", timeParameter.name)}
 			return
 		}
 
-		at = &parsedTime
-		delete(tags, "_at")
+		*timeParameter.field = &parsedTime
+		delete(query, timeParameter.name)
+	}
+
+	if legalHoldStrings, hasLegalHold := query["_legalhold"]; hasLegalHold {
+		if len(legalHoldStrings) > 1 {
+			err = &searchParameterError{"InvalidParameter", "The '_legalHold' parameter was specified multiple times in the URL."}
+			return
+		}
+
+		legalHold, parseErr := strconv.ParseBool(legalHoldStrings[0])
+		if parseErr != nil {
+			err = &searchParameterError{"InvalidParameter", "The format of the '_legalHold' parameter is invalid"}
+			return
+		}
+
+		filter.LegalHold = &legalHold
+		delete(query, "_legalhold")
 	}
 
 	pageSize = 100
 
-	if limitStrings, hasLimit := tags["_limit"]; hasLimit {
+	if limitStrings, hasLimit := query["_limit"]; hasLimit {
 
 		pageSize, _ = strconv.Atoi(limitStrings[0])
 		if pageSize > 100 || pageSize <= 0 {
 			pageSize = 100
 		}
 
-		delete(tags, "_limit")
+		delete(query, "_limit")
 	}
 
-	if cts, hasCt := tags["_ct"]; hasCt {
+	if cts, hasCt := query["_ct"]; hasCt {
 		if len(cts) > 1 {
-			w.WriteHeader(http.StatusBadRequest)
-			writeJson(w, r, CreateErrorResponse("InvalidContinuationToken", "The'_ct' parameter was specified multiple times in the URL."))
+			err = &searchParameterError{"InvalidContinuationToken", "The'_ct' parameter was specified multiple times in the URL."}
 			return
 		}
 
 		ct = (*core.ContinutationToken)(&cts[0])
-		delete(tags, "_ct")
+		delete(query, "_ct")
 	}
 
-	ok = true
+	for key, values := range query {
+		if strings.HasPrefix(key, "_") {
+			options[key] = values
+			continue
+		}
+
+		tagName, operator, keyErr := parseFilterKey(key)
+		if keyErr != nil {
+			err = &searchParameterError{"InvalidQuery", keyErr.Error()}
+			return
+		}
+
+		if operator == core.FilterOperatorExists || operator == core.FilterOperatorAbsent {
+			// these operators do not take a value, e.g. `?approved:absent`
+			filter.Tags = append(filter.Tags, core.TagFilter{Tag: tagName, Operator: operator})
+			continue
+		}
+
+		if operator == core.FilterOperatorIn {
+			// a single condition that matches any of the comma-separated values
+			inFilter := core.TagFilter{Tag: tagName, Operator: operator}
+			for _, v := range values {
+				inFilter.Values = append(inFilter.Values, strings.Split(v, ",")...)
+			}
+
+			filter.Tags = append(filter.Tags, inFilter)
+			continue
+		}
+
+		for _, v := range values {
+			filter.Tags = append(filter.Tags, nullAwareTagFilter(tagName, operator, v))
+		}
+	}
+
+	// make the generated query deterministic
+	sort.SliceStable(filter.Tags, func(i, j int) bool { return filter.Tags[i].Tag < filter.Tags[j].Tag })
+
+	return
+}
+
+// For the optional system tags, a value of `$null` refers to the tag not being set
+func nullAwareTagFilter(tagName string, operator core.FilterOperator, value string) core.TagFilter {
+	if value == NullSubject && (tagName == "device" || tagName == "name" || tagName == "session") {
+		switch operator {
+		case core.FilterOperatorEqual:
+			return core.TagFilter{Tag: tagName, Operator: core.FilterOperatorAbsent}
+		case core.FilterOperatorNotEqual:
+			return core.TagFilter{Tag: tagName, Operator: core.FilterOperatorExists}
+		}
+	}
+
+	return core.TagFilter{Tag: tagName, Operator: operator, Value: value}
+}
+
+// Parses the optional `_sort` parameter, which is a sort key optionally prefixed with `-` for descending order.
+func getSortOrder(w http.ResponseWriter, r *http.Request, options url.Values) (sort core.SortOrder, ok bool) {
+	sortStrings, hasSort := options["_sort"]
+	if !hasSort {
+		return core.SortOrder{}, true
+	}
+
+	if len(sortStrings) > 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJson(w, r, CreateErrorResponse("InvalidParameter", "The '_sort' parameter was specified multiple times in the URL."))
+		return
+	}
+
+	sortString := sortStrings[0]
+	sort.Ascending = !strings.HasPrefix(sortString, "-")
+	sortString = strings.TrimPrefix(sortString, "-")
+
+	for _, key := range []core.SortKey{core.SortKeyCreatedAt, core.SortKeyName, core.SortKeySize, core.SortKeyExpiresAt} {
+		if strings.EqualFold(sortString, string(key)) {
+			sort.Key = key
+			return sort, true
+		}
+	}
+
+	w.WriteHeader(http.StatusBadRequest)
+	writeJson(w, r, CreateErrorResponse("InvalidParameter", fmt.Sprintf("The '_sort' parameter must be one of '%s', '%s', '%s', or '%s', optionally prefixed with '-' for descending order.",
+		core.SortKeyCreatedAt, core.SortKeyName, core.SortKeySize, core.SortKeyExpiresAt)))
 	return
 }
+
+// Parses an optional boolean parameter. The parameter can also be given as a flag without a value, meaning true.
+func getFlagOption(w http.ResponseWriter, r *http.Request, options url.Values, name string) (value bool, ok bool) {
+	values, hasValue := options[strings.ToLower(name)]
+	if !hasValue {
+		return false, true
+	}
+
+	if len(values) > 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJson(w, r, CreateErrorResponse("InvalidParameter", fmt.Sprintf("The '%s' parameter was specified multiple times in the URL.", name)))
+		return false, false
+	}
+
+	if values[0] == "" {
+		return true, true
+	}
+
+	value, err := strconv.ParseBool(values[0])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJson(w, r, CreateErrorResponse("InvalidParameter", fmt.Sprintf("The format of the '%s' parameter is invalid", name)))
+		return false, false
+	}
+
+	return value, true
+}
+
+// Splits a query parameter name of the form `tag` or `tag:operator`.
+func parseFilterKey(key string) (tagName string, operator core.FilterOperator, err error) {
+	tagName, operatorString, hasOperator := strings.Cut(key, ":")
+	if !hasOperator {
+		return tagName, core.FilterOperatorEqual, nil
+	}
+
+	operator = core.FilterOperator(operatorString)
+	if !supportedFilterOperators[operator] {
+		return "", "", fmt.Errorf("the operator '%s' used with '%s' is not supported", operatorString, tagName)
+	}
+
+	if tagName == "subject" && operator != core.FilterOperatorEqual {
+		return "", "", fmt.Errorf("the 'subject' parameter only supports equality")
+	}
+
+	return tagName, operator, nil
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/ismrmrd/mrd-storage-server/core"
+	"github.com/ismrmrd/mrd-storage-server/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xorcare/pointer"
+)
+
+func TestStreamedSearchReadsAllPages(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockMetadataDatabase := mocks.NewMockMetadataDatabase(mockCtrl)
+	mockBlobStore := mocks.NewMockBlobStore(mockCtrl)
+
+	ct := core.ContinutationToken("next")
+	gomock.InOrder(
+		mockMetadataDatabase.EXPECT().
+			SearchBlobMetadata(gomock.Any(), gomock.Any(), gomock.Any(), nil, streamPageSize, gomock.Any()).
+			Return([]core.BlobInfo{{Key: core.BlobKey{Subject: "a", Id: uuid.New()}}, {Key: core.BlobKey{Subject: "a", Id: uuid.New()}}}, &ct, nil),
+		mockMetadataDatabase.EXPECT().
+			SearchBlobMetadata(gomock.Any(), gomock.Any(), gomock.Any(), &ct, streamPageSize, gomock.Any()).
+			Return([]core.BlobInfo{{Key: core.BlobKey{Subject: "a", Id: uuid.New()}}}, nil, nil),
+	)
+
+	router := BuildRouter(mockMetadataDatabase, mockBlobStore, nil, false)
+
+	req := httptest.NewRequest("GET", "/v1/blobs?subject=a", nil)
+	req.Header.Set("Accept", NdjsonContentType)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, NdjsonContentType, resp.Header().Get("Content-Type"))
+	assert.True(t, resp.Flushed)
+
+	lines := 0
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		lines++
+	}
+
+	assert.Equal(t, 3, lines)
+}
+
+func TestStreamedSearchAbortsResponseOnLaterFailure(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockMetadataDatabase := mocks.NewMockMetadataDatabase(mockCtrl)
+	mockBlobStore := mocks.NewMockBlobStore(mockCtrl)
+
+	ct := core.ContinutationToken("next")
+	gomock.InOrder(
+		mockMetadataDatabase.EXPECT().
+			SearchBlobMetadata(gomock.Any(), gomock.Any(), gomock.Any(), nil, gomock.Any(), gomock.Any()).
+			Return([]core.BlobInfo{{Key: core.BlobKey{Subject: "a", Id: uuid.New()}}}, &ct, nil),
+		mockMetadataDatabase.EXPECT().
+			SearchBlobMetadata(gomock.Any(), gomock.Any(), gomock.Any(), &ct, gomock.Any(), gomock.Any()).
+			Return(nil, nil, errors.New("connection lost")),
+	)
+
+	router := BuildRouter(mockMetadataDatabase, mockBlobStore, nil, false)
+
+	req := httptest.NewRequest("GET", "/v1/blobs?subject=a", nil)
+	req.Header.Set("Accept", NdjsonContentType)
+	resp := httptest.NewRecorder()
+
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() { router.ServeHTTP(resp, req) })
+}
+
+func TestSearchInlinesOnlySmallBlobs(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockMetadataDatabase := mocks.NewMockMetadataDatabase(mockCtrl)
+	mockBlobStore := mocks.NewMockBlobStore(mockCtrl)
+
+	small := core.BlobInfo{Key: core.BlobKey{Subject: "a", Id: uuid.New()}, Size: pointer.Int64(5)}
+	large := core.BlobInfo{Key: core.BlobKey{Subject: "a", Id: uuid.New()}, Size: pointer.Int64(1024)}
+	unknownSize := core.BlobInfo{Key: core.BlobKey{Subject: "a", Id: uuid.New()}}
+
+	mockMetadataDatabase.EXPECT().
+		SearchBlobMetadata(gomock.Any(), gomock.Any(), gomock.Any(), nil, gomock.Any(), gomock.Any()).
+		Return([]core.BlobInfo{small, large, unknownSize}, nil, nil)
+
+	mockBlobStore.EXPECT().
+		ReadBlob(gomock.Any(), gomock.Any(), small.Key).
+		DoAndReturn(func(ctx context.Context, writer io.Writer, key core.BlobKey) error {
+			_, err := writer.Write([]byte("hello"))
+			return err
+		})
+
+	router := BuildRouter(mockMetadataDatabase, mockBlobStore, nil, false)
+
+	req := httptest.NewRequest("GET", "/v1/blobs?subject=a&_include=data&_maxInline=1000", nil)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	searchResponse := SearchResponse{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&searchResponse))
+	require.Len(t, searchResponse.Items, 3)
+	assert.Equal(t, "aGVsbG8=", searchResponse.Items[0]["inlineData"])
+	assert.Equal(t, InlineEncodingBase64, searchResponse.Items[0]["inlineEncoding"])
+	assert.NotContains(t, searchResponse.Items[1], "inlineData")
+	assert.NotContains(t, searchResponse.Items[2], "inlineData")
+}
+
+// Ensure that searches with inlined content read small pages, since each result can hold up to maxMaxInlineSize bytes.
+func TestSearchWithInlineDataUsesSmallPages(t *testing.T) {
+	for accept, query := range map[string]string{"": "&_limit=100", NdjsonContentType: ""} {
+		mockCtrl := gomock.NewController(t)
+		mockMetadataDatabase := mocks.NewMockMetadataDatabase(mockCtrl)
+		mockBlobStore := mocks.NewMockBlobStore(mockCtrl)
+
+		mockMetadataDatabase.EXPECT().
+			SearchBlobMetadata(gomock.Any(), gomock.Any(), gomock.Any(), nil, inlinePageSize, gomock.Any()).
+			Return([]core.BlobInfo{{Key: core.BlobKey{Subject: "a", Id: uuid.New()}}}, nil, nil)
+
+		router := BuildRouter(mockMetadataDatabase, mockBlobStore, nil, false)
+
+		req := httptest.NewRequest("GET", "/v1/blobs?subject=a&_include=data"+query, nil)
+		req.Header.Set("Accept", accept)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		require.Equal(t, http.StatusOK, resp.Code, accept)
+	}
+}
@@ -5,6 +5,51 @@ type SearchResponse struct {
 	NextLink string                   `json:"nextLink,omitempty"`
 }
 
+type ListResponse struct {
+	Items    []string `json:"items"`
+	NextLink string   `json:"nextLink,omitempty"`
+}
+
+type CountResponse struct {
+	Count int64 `json:"count"`
+}
+
+type FacetsResponse struct {
+	Facets map[string][]FacetValue `json:"facets"`
+}
+
+type FacetValue struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+type BatchResult struct {
+	Status int                    `json:"status"`
+	Item   map[string]interface{} `json:"item,omitempty"`
+	Error  *ErrorInfo             `json:"error,omitempty"`
+}
+
+type LegalHoldRequest struct {
+	Reason string `json:"reason"`
+}
+
+type DeleteResponse struct {
+	Count     int   `json:"count"`
+	DryRun    bool  `json:"dryRun,omitempty"`
+	Remaining int64 `json:"remaining,omitempty"`
+}
+
+type PurgeSubjectResponse struct {
+	Subject       string   `json:"subject"`
+	ErasedBlobIds []string `json:"erasedBlobIds"`
+	StartedAt     string   `json:"startedAt"`
+	CompletedAt   string   `json:"completedAt"`
+}
+
 // Based on https://github.com/microsoft/api-guidelines/blob/vNext/Guidelines.md#7102-error-condition-responses
 type ErrorResponse struct {
 	Error ErrorInfo `json:"error"`
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ismrmrd/mrd-storage-server/core"
+	"github.com/rs/zerolog/log"
+)
+
+const MergePatchContentType = "application/merge-patch+json"
+
+// Fields of the metadata document that cannot be changed with a PATCH request,
+// in addition to the reserved tag names
+var immutableMetadataFields = map[string]bool{
+	"subject": true,
+	"data":    true,
+}
+
+// Applies a JSON merge patch (RFC 7396) to the tags of a blob. A tag set to a string or an array of strings
+// replaces the values of the tag, and a tag set to null is removed. The expiration can be changed with either
+// `expires` or `_ttl`. With an If-Match header, the update only succeeds if the metadata has not changed since
+// the ETag was returned.
+func (handler *Handler) UpdateBlobMetadata(w http.ResponseWriter, r *http.Request) {
+
+	combinedId := chi.URLParam(r, "combined-id")
+	key, ok := getBlobSubjectAndIdFromCombinedId(combinedId)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != MergePatchContentType && mediaType != "application/json" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		writeJson(w, r, CreateErrorResponse("UnsupportedMediaType", fmt.Sprintf("The Content-Type of the request must be '%s'.", MergePatchContentType)))
+		return
+	}
+
+	expectedVersions, ok := getExpectedVersions(r)
+	if !ok {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		writeJson(w, r, CreateErrorResponse("PreconditionFailed", "The blob metadata does not match the If-Match header."))
+		return
+	}
+
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJson(w, r, CreateErrorResponse("InvalidBody", fmt.Sprintf("The request body must be a JSON object: %v", err)))
+		return
+	}
+
+	update, err := parseMetadataPatch(patch)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJson(w, r, CreateErrorResponse("InvalidTag", err.Error()))
+		return
+	}
+
+	blobInfo, err := handler.db.UpdateBlobMetadata(r.Context(), key, update, expectedVersions, time.Now())
+	if err != nil {
+		if errors.Is(err, core.ErrRecordNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if errors.Is(err, core.ErrVersionMismatch) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			writeJson(w, r, CreateErrorResponse("PreconditionFailed", "The blob metadata does not match the If-Match header."))
+			return
+		}
+
+		log.Ctx(r.Context()).Error().Msgf("Failed to update blob metadata: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", formatVersionETag(blobInfo.Version))
+	writeJson(w, r, CreateBlobInfo(r, blobInfo))
+}
+
+// Converts the fields of a merge patch to an update of the blob's tags
+func parseMetadataPatch(patch map[string]json.RawMessage) (*core.BlobMetadataUpdate, error) {
+	update := &core.BlobMetadataUpdate{}
+	setTags := make(map[string]bool)
+
+	for field, rawValue := range patch {
+		tagName := strings.ToLower(field)
+		if tagName == "expires" || tagName == "_ttl" {
+			if err := parseExpirationPatch(update, tagName, rawValue); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		if immutableMetadataFields[tagName] || strings.HasPrefix(tagName, "_") {
+			return nil, fmt.Errorf("the '%s' field cannot be modified", field)
+		}
+
+		if err := ValidateTagName(tagName, nil); err != nil {
+			return nil, err
+		}
+
+		if setTags[tagName] {
+			return nil, fmt.Errorf("the '%s' tag was given more than once", tagName)
+		}
+
+		setTags[tagName] = true
+
+		if string(rawValue) == "null" {
+			update.Remove = append(update.Remove, tagName)
+			continue
+		}
+
+		var values []string
+		var single string
+		if err := json.Unmarshal(rawValue, &single); err == nil {
+			values = []string{single}
+		} else if err := json.Unmarshal(rawValue, &values); err != nil {
+			return nil, fmt.Errorf("the value of '%s' must be a string, an array of strings, or null", field)
+		}
+
+		if len(values) == 0 {
+			// an empty array is the same as removing the tag
+			update.Remove = append(update.Remove, tagName)
+			continue
+		}
+
+		if err := ValidateAndStoreTag(&update.Set, tagName, values); err != nil {
+			return nil, err
+		}
+	}
+
+	return update, nil
+}
+
+// Sets the new expiration of an update from an absolute `expires` time or a `_ttl` duration from now.
+// A null value removes the expiration.
+func parseExpirationPatch(update *core.BlobMetadataUpdate, field string, rawValue json.RawMessage) error {
+	if update.ExpiresAt != nil || update.RemoveExpiration {
+		return errors.New("only one of 'expires' and '_ttl' can be given")
+	}
+
+	if string(rawValue) == "null" {
+		update.RemoveExpiration = true
+		return nil
+	}
+
+	var value string
+	if err := json.Unmarshal(rawValue, &value); err != nil {
+		return fmt.Errorf("the value of '%s' must be a string or null", field)
+	}
+
+	if field == "_ttl" {
+		if err := ValidateTimeToLive(field, []string{value}); err != nil {
+			return err
+		}
+
+		duration, _ := time.ParseDuration(value)
+		expiresAt := time.Now().Add(duration)
+		update.ExpiresAt = &expiresAt
+		return nil
+	}
+
+	if err := ValidateExpiresAt(field, []string{value}); err != nil {
+		return err
+	}
+
+	expiresAt, _ := time.Parse(time.RFC3339Nano, value)
+	update.ExpiresAt = &expiresAt
+	return nil
+}
+
+// Parses the If-Match header into the metadata versions that it matches, or nil if it matches any version.
+// The update is made if the current version is any of them. Returns ok=false if the header cannot match
+// any version.
+func getExpectedVersions(r *http.Request) (expectedVersions []int64, ok bool) {
+	ifMatch := r.Header.Values("If-Match")
+	if len(ifMatch) == 0 {
+		return nil, true
+	}
+
+	expectedVersions = []int64{}
+	for _, candidate := range strings.Split(strings.Join(ifMatch, ","), ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return nil, true
+		}
+
+		// If-Match uses the strong comparison function, so weak ETags never match
+		if len(candidate) > 2 && strings.HasPrefix(candidate, `"`) && strings.HasSuffix(candidate, `"`) {
+			if version, err := strconv.ParseInt(candidate[1:len(candidate)-1], 10, 64); err == nil {
+				expectedVersions = append(expectedVersions, version)
+			}
+		}
+	}
+
+	return expectedVersions, len(expectedVersions) > 0
+}
+
+func formatVersionETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
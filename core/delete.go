@@ -0,0 +1,78 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const deletePageSize = 200
+
+// Deleting a blob follows the write path in reverse: the metadata is first moved back to the staged state, which
+// hides it from reads, then the blob is deleted from the blob store, and finally the metadata is removed. If the
+// process crashes before the last step completes, the staged record is cleaned up by CollectGarbage like any other
+// orphaned staged record. Blobs under legal hold cannot be deleted and ErrLegalHold is returned.
+func DeleteBlob(ctx context.Context, db MetadataDatabase, store BlobStore, key BlobKey) error {
+	if err := db.StageBlobMetadataForDeletion(ctx, key); err != nil {
+		return err
+	}
+
+	return deleteBlobAndMetadata(ctx, db, store, key)
+}
+
+// Deletes up to maxCount blobs matching the given search filter and returns the number of blobs that were
+// deleted and, if maxCount was reached, the number of matching blobs that remain. Since deleted blobs no longer
+// appear in search results, we repeatedly delete the first page of results until there are none left. Blobs
+// under legal hold are skipped. On failure, the number of blobs deleted before the failure is returned.
+func DeleteMatchingBlobs(ctx context.Context, db MetadataDatabase, store BlobStore, filter *SearchFilter, maxCount int) (deleted int, remaining int64, err error) {
+	filter = ExcludeBlobsOnLegalHold(filter)
+	if filter == nil {
+		return 0, 0, nil
+	}
+
+	for deleted < maxCount {
+		pageSize := deletePageSize
+		if maxCount-deleted < pageSize {
+			pageSize = maxCount - deleted
+		}
+
+		results, _, err := db.SearchBlobMetadata(ctx, filter, SortOrder{}, nil, pageSize, time.Now())
+		if err != nil {
+			return deleted, 0, err
+		}
+
+		if len(results) == 0 {
+			return deleted, 0, nil
+		}
+
+		for _, blob := range results {
+			err = DeleteBlob(ctx, db, store, blob.Key)
+			if err != nil {
+				if errors.Is(err, ErrRecordNotFound) || errors.Is(err, ErrLegalHold) {
+					// the blob was deleted or placed under legal hold by a concurrent request
+					continue
+				}
+
+				return deleted, 0, err
+			}
+
+			deleted++
+		}
+	}
+
+	remaining, err = db.CountBlobMetadata(ctx, filter, time.Now())
+	return deleted, remaining, err
+}
+
+// Returns a copy of the filter that does not match blobs under legal hold,
+// or nil if the filter only matches blobs under legal hold.
+func ExcludeBlobsOnLegalHold(filter *SearchFilter) *SearchFilter {
+	if filter.LegalHold != nil && *filter.LegalHold {
+		return nil
+	}
+
+	notHeld := false
+	excluding := *filter
+	excluding.LegalHold = &notHeld
+	return &excluding
+}
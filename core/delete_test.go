@@ -0,0 +1,118 @@
+package core_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/ismrmrd/mrd-storage-server/core"
+	"github.com/ismrmrd/mrd-storage-server/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// Ensure that when the blob store deletion fails, the metadata
+// is left in the staged state for garbage collection to clean up.
+func TestDeleteBlobStoreFailureLeavesMetadataStaged(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+
+	db := mocks.NewMockMetadataDatabase(mockCtrl)
+	store := mocks.NewMockBlobStore(mockCtrl)
+
+	key := core.BlobKey{Subject: "s", Id: uuid.UUID{}}
+
+	gomock.InOrder(
+		db.EXPECT().StageBlobMetadataForDeletion(gomock.Any(), key).Return(nil),
+		store.EXPECT().DeleteBlob(gomock.Any(), key).Return(errors.New("failed to delete")),
+	)
+
+	err := core.DeleteBlob(context.Background(), db, store, key)
+	assert.NotNil(t, err)
+}
+
+// Ensure that blobs deleted by a concurrent request are skipped
+// and not included in the count of deleted blobs.
+func TestDeleteMatchingBlobsSkipsConcurrentlyDeletedBlobs(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+
+	db := mocks.NewMockMetadataDatabase(mockCtrl)
+	store := mocks.NewMockBlobStore(mockCtrl)
+
+	deletedKey := core.BlobKey{Subject: "s", Id: uuid.New()}
+	key := core.BlobKey{Subject: "s", Id: uuid.New()}
+	filter := &core.SearchFilter{Tags: []core.TagFilter{{Tag: "subject", Operator: core.FilterOperatorEqual, Value: "s"}}}
+	deletable := core.ExcludeBlobsOnLegalHold(filter)
+
+	gomock.InOrder(
+		db.EXPECT().
+			SearchBlobMetadata(gomock.Any(), deletable, core.SortOrder{}, nil, gomock.Any(), gomock.Any()).
+			Return([]core.BlobInfo{{Key: deletedKey}, {Key: key}}, nil, nil),
+		db.EXPECT().
+			SearchBlobMetadata(gomock.Any(), deletable, core.SortOrder{}, nil, gomock.Any(), gomock.Any()).
+			Return([]core.BlobInfo{}, nil, nil),
+	)
+
+	db.EXPECT().StageBlobMetadataForDeletion(gomock.Any(), deletedKey).Return(core.ErrRecordNotFound)
+	db.EXPECT().StageBlobMetadataForDeletion(gomock.Any(), key).Return(nil)
+	store.EXPECT().DeleteBlob(gomock.Any(), key).Return(nil)
+	db.EXPECT().DeleteBlobMetadata(gomock.Any(), key).Return(nil)
+
+	deleted, remaining, err := core.DeleteMatchingBlobs(context.Background(), db, store, filter, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, deleted)
+	assert.Zero(t, remaining)
+}
+
+// Ensure that no more than the maximum number of blobs are deleted
+// and that the number of matching blobs left is returned.
+func TestDeleteMatchingBlobsStopsAtMaxCount(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+
+	db := mocks.NewMockMetadataDatabase(mockCtrl)
+	store := mocks.NewMockBlobStore(mockCtrl)
+
+	key := core.BlobKey{Subject: "s", Id: uuid.New()}
+	filter := &core.SearchFilter{Tags: []core.TagFilter{{Tag: "subject", Operator: core.FilterOperatorEqual, Value: "s"}}}
+	deletable := core.ExcludeBlobsOnLegalHold(filter)
+
+	gomock.InOrder(
+		db.EXPECT().
+			SearchBlobMetadata(gomock.Any(), deletable, core.SortOrder{}, nil, 1, gomock.Any()).
+			Return([]core.BlobInfo{{Key: key}}, nil, nil),
+		db.EXPECT().StageBlobMetadataForDeletion(gomock.Any(), key).Return(nil),
+		store.EXPECT().DeleteBlob(gomock.Any(), key).Return(nil),
+		db.EXPECT().DeleteBlobMetadata(gomock.Any(), key).Return(nil),
+		db.EXPECT().CountBlobMetadata(gomock.Any(), deletable, gomock.Any()).Return(int64(4), nil),
+	)
+
+	deleted, remaining, err := core.DeleteMatchingBlobs(context.Background(), db, store, filter, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, deleted)
+	assert.Equal(t, int64(4), remaining)
+}
+
+// Ensure that when a deletion fails, the number of blobs
+// deleted before the failure is returned with the error.
+func TestDeleteMatchingBlobsFailureReturnsDeletedCount(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+
+	db := mocks.NewMockMetadataDatabase(mockCtrl)
+	store := mocks.NewMockBlobStore(mockCtrl)
+
+	deletedKey := core.BlobKey{Subject: "s", Id: uuid.New()}
+	failedKey := core.BlobKey{Subject: "s", Id: uuid.New()}
+	filter := &core.SearchFilter{Tags: []core.TagFilter{{Tag: "subject", Operator: core.FilterOperatorEqual, Value: "s"}}}
+
+	db.EXPECT().
+		SearchBlobMetadata(gomock.Any(), gomock.Any(), core.SortOrder{}, nil, gomock.Any(), gomock.Any()).
+		Return([]core.BlobInfo{{Key: deletedKey}, {Key: failedKey}}, nil, nil)
+	db.EXPECT().StageBlobMetadataForDeletion(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+	store.EXPECT().DeleteBlob(gomock.Any(), deletedKey).Return(nil)
+	db.EXPECT().DeleteBlobMetadata(gomock.Any(), deletedKey).Return(nil)
+	store.EXPECT().DeleteBlob(gomock.Any(), failedKey).Return(errors.New("failed to delete"))
+
+	deleted, _, err := core.DeleteMatchingBlobs(context.Background(), db, store, filter, 10)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, deleted)
+}
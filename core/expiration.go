@@ -0,0 +1,36 @@
+package core
+
+import (
+	"context"
+	"time"
+)
+
+// The longest time that the expiration of a blob with an idle time-to-live can lag behind its last read
+const maxIdleExpirationLag = time.Hour
+
+// Pushes the expiration of a blob with an idle time-to-live forward after it has been read. To avoid writing to
+// the database on every read, the expiration is only updated once it has fallen behind by a tenth of the idle
+// time-to-live, up to maxIdleExpirationLag. A blob is therefore never removed sooner than that much before its
+// idle time-to-live has elapsed since it was last read.
+func RefreshIdleExpiration(ctx context.Context, db MetadataDatabase, blob *BlobInfo, now time.Time) error {
+	if blob.IdleTimeToLive == nil || blob.ExpiresAt == nil {
+		return nil
+	}
+
+	lag := *blob.IdleTimeToLive / 10
+	if lag > maxIdleExpirationLag {
+		lag = maxIdleExpirationLag
+	}
+
+	expiresAt := now.Add(*blob.IdleTimeToLive)
+	if blob.ExpiresAt.After(expiresAt.Add(-lag)) {
+		return nil
+	}
+
+	if err := db.ExtendBlobExpiration(ctx, blob.Key, expiresAt); err != nil {
+		return err
+	}
+
+	blob.ExpiresAt = &expiresAt
+	return nil
+}
@@ -0,0 +1,36 @@
+package core_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/ismrmrd/mrd-storage-server/core"
+	"github.com/ismrmrd/mrd-storage-server/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// Ensure that reading a blob shortly after its expiration was extended
+// does not write to the database again.
+func TestRefreshIdleExpirationIsThrottled(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	db := mocks.NewMockMetadataDatabase(mockCtrl)
+
+	now := time.Now()
+	idleTimeToLive := 10 * time.Hour
+	key := core.BlobKey{Subject: "s", Id: uuid.New()}
+
+	recentlyRead := now.Add(idleTimeToLive - 30*time.Minute)
+	blob := &core.BlobInfo{Key: key, ExpiresAt: &recentlyRead, IdleTimeToLive: &idleTimeToLive}
+	assert.Nil(t, core.RefreshIdleExpiration(context.Background(), db, blob, now))
+	assert.Equal(t, recentlyRead, *blob.ExpiresAt)
+
+	db.EXPECT().ExtendBlobExpiration(gomock.Any(), key, now.Add(idleTimeToLive)).Return(nil)
+
+	notReadRecently := now.Add(idleTimeToLive - 2*time.Hour)
+	blob.ExpiresAt = &notReadRecently
+	assert.Nil(t, core.RefreshIdleExpiration(context.Background(), db, blob, now))
+	assert.Equal(t, now.Add(idleTimeToLive), *blob.ExpiresAt)
+}
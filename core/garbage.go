@@ -35,6 +35,10 @@ func CollectGarbage(ctx context.Context, db MetadataDatabase, store BlobStore, o
 
 func processExpiredKey(ctx context.Context, db MetadataDatabase, store BlobStore, key BlobKey) error {
 	log.Ctx(ctx).Info().Msgf("Removing expired key %v", key)
+	return deleteBlobAndMetadata(ctx, db, store, key)
+}
+
+func deleteBlobAndMetadata(ctx context.Context, db MetadataDatabase, store BlobStore, key BlobKey) error {
 	if err := store.DeleteBlob(ctx, key); err != nil {
 		return err
 	}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var ErrDigestMismatch = errors.New("the SHA-256 digest of the content does not match the expected digest")
+
+// Recreates a blob from an export, following the same write path as creating a blob: the metadata is staged and
+// the content is written to the blob store. The metadata is left staged, which hides the blob from reads, until
+// CompleteImportedBlob is called, so that several blobs can be made visible once all of them have been imported.
+// If expectedSha256 is not nil and the content does not match it, the blob is removed and ErrDigestMismatch
+// is returned.
+//
+// If legalHoldReason is not nil, the blob is placed under legal hold when it is completed. Until then, it is
+// staged without its expiration time, which may have passed, so that garbage collection leaves it alone.
+func ImportBlob(ctx context.Context, db MetadataDatabase, store BlobStore, key BlobKey, tags *BlobTags, legalHoldReason *string, expectedSha256 []byte, contents io.Reader) (*BlobInfo, error) {
+	stagedTags := tags
+	if legalHoldReason != nil && tags.ExpiresAt != nil {
+		withoutExpiration := *tags
+		withoutExpiration.ExpiresAt = nil
+		stagedTags = &withoutExpiration
+	}
+
+	blobInfo, err := db.StageBlobMetadata(ctx, key, stagedTags)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+	body := &CountingReader{Reader: io.TeeReader(contents, hash)}
+	if err := store.SaveBlob(ctx, body, key); err != nil {
+		if err := db.DeleteBlobMetadata(ctx, key); err != nil {
+			// garbage collection will clean up the staged metadata
+			log.Ctx(ctx).Error().Msgf("Failed to revert staged blob metadata: %v", err)
+		}
+
+		return nil, err
+	}
+
+	sha256 := hash.Sum(nil)
+	if expectedSha256 != nil && !bytes.Equal(expectedSha256, sha256) {
+		if err := deleteBlobAndMetadata(ctx, db, store, key); err != nil {
+			return nil, err
+		}
+
+		return nil, ErrDigestMismatch
+	}
+
+	blobInfo.Size = &body.Count
+	blobInfo.Sha256 = sha256
+	blobInfo.LegalHoldReason = legalHoldReason
+	if legalHoldReason != nil && tags.ExpiresAt != nil {
+		blobInfo.ExpiresAt = tags.ExpiresAt
+	}
+
+	return blobInfo, nil
+}
+
+// Makes a blob staged by ImportBlob visible with the given creation time, placing it under legal hold
+// with its original expiration time if it was imported with a hold.
+func CompleteImportedBlob(ctx context.Context, db MetadataDatabase, blobInfo *BlobInfo, createdAt time.Time) (*BlobInfo, error) {
+	if err := db.CompleteStagedBlobMetadata(ctx, blobInfo.Key, *blobInfo.Size, blobInfo.Sha256, &createdAt); err != nil {
+		return nil, err
+	}
+
+	if blobInfo.LegalHoldReason == nil {
+		completed := *blobInfo
+		completed.CreatedAt = createdAt
+		return &completed, nil
+	}
+
+	// the expiration and the hold are set together so that the blob never appears to have expired
+	update := BlobMetadataUpdate{LegalHoldReason: blobInfo.LegalHoldReason, ExpiresAt: blobInfo.ExpiresAt}
+	return db.UpdateBlobMetadata(ctx, blobInfo.Key, &update, nil, time.Now())
+}
+
+// Removes a blob imported by ImportBlob, whether or not it has been completed. The legal hold of a
+// completed blob that was imported with one is released first.
+func DiscardImportedBlob(ctx context.Context, db MetadataDatabase, store BlobStore, blobInfo *BlobInfo) error {
+	if blobInfo.LegalHoldReason != nil {
+		update := BlobMetadataUpdate{ReleaseLegalHold: true}
+		if _, err := db.UpdateBlobMetadata(ctx, blobInfo.Key, &update, nil, time.Now()); err != nil && !errors.Is(err, ErrRecordNotFound) {
+			return err
+		}
+	}
+
+	err := DeleteBlob(ctx, db, store, blobInfo.Key)
+	if errors.Is(err, ErrRecordNotFound) {
+		// the blob has not been completed
+		return deleteBlobAndMetadata(ctx, db, store, blobInfo.Key)
+	}
+
+	return err
+}
+
+// Counts the bytes read from the underlying reader
+type CountingReader struct {
+	Reader io.Reader
+	Count  int64
+}
+
+func (r *CountingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.Count += int64(n)
+	return n, err
+}
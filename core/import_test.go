@@ -0,0 +1,70 @@
+package core_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/ismrmrd/mrd-storage-server/core"
+	"github.com/ismrmrd/mrd-storage-server/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// Ensure that content that does not match the expected digest is removed
+// and its staged metadata is never completed.
+func TestImportBlobDigestMismatchRemovesBlob(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+
+	db := mocks.NewMockMetadataDatabase(mockCtrl)
+	store := mocks.NewMockBlobStore(mockCtrl)
+
+	key := core.BlobKey{Subject: "s", Id: uuid.New()}
+	tags := &core.BlobTags{}
+
+	gomock.InOrder(
+		db.EXPECT().StageBlobMetadata(gomock.Any(), key, tags).Return(&core.BlobInfo{Key: key}, nil),
+		store.EXPECT().SaveBlob(gomock.Any(), gomock.Any(), key).DoAndReturn(func(ctx context.Context, contents io.Reader, key core.BlobKey) error {
+			_, err := io.ReadAll(contents)
+			return err
+		}),
+		store.EXPECT().DeleteBlob(gomock.Any(), key).Return(nil),
+		db.EXPECT().DeleteBlobMetadata(gomock.Any(), key).Return(nil),
+	)
+
+	_, err := core.ImportBlob(context.Background(), db, store, key, tags, nil, make([]byte, 32), strings.NewReader("content"))
+	assert.ErrorIs(t, err, core.ErrDigestMismatch)
+}
+
+// Ensure that a blob imported under legal hold is staged without its expiration, which
+// may have passed, and that the hold and the expiration are set together on completion.
+func TestImportBlobWithLegalHoldSetsExpirationWithHold(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+
+	db := mocks.NewMockMetadataDatabase(mockCtrl)
+	store := mocks.NewMockBlobStore(mockCtrl)
+
+	key := core.BlobKey{Subject: "s", Id: uuid.New()}
+	expiresAt := time.Now().Add(-time.Hour)
+	reason := "audit"
+	createdAt := time.Now().Add(-48 * time.Hour)
+
+	gomock.InOrder(
+		db.EXPECT().StageBlobMetadata(gomock.Any(), key, &core.BlobTags{}).Return(&core.BlobInfo{Key: key}, nil),
+		store.EXPECT().SaveBlob(gomock.Any(), gomock.Any(), key).Return(nil),
+		db.EXPECT().CompleteStagedBlobMetadata(gomock.Any(), key, int64(0), gomock.Any(), &createdAt).Return(nil),
+		db.EXPECT().
+			UpdateBlobMetadata(gomock.Any(), key, &core.BlobMetadataUpdate{LegalHoldReason: &reason, ExpiresAt: &expiresAt}, gomock.Nil(), gomock.Any()).
+			Return(&core.BlobInfo{Key: key, ExpiresAt: &expiresAt, LegalHoldReason: &reason}, nil),
+	)
+
+	blobInfo, err := core.ImportBlob(context.Background(), db, store, key, &core.BlobTags{ExpiresAt: &expiresAt}, &reason, nil, strings.NewReader(""))
+	assert.Nil(t, err)
+
+	blobInfo, err = core.CompleteImportedBlob(context.Background(), db, blobInfo, createdAt)
+	assert.Nil(t, err)
+	assert.Equal(t, &reason, blobInfo.LegalHoldReason)
+}
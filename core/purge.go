@@ -0,0 +1,97 @@
+package core
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// A record of the data that was erased by PurgeSubject.
+type SubjectPurgeRecord struct {
+	Subject     string
+	ErasedBlobs []BlobKey
+	StartedAt   time.Time
+	CompletedAt time.Time
+}
+
+// Removes all blobs and metadata for a subject, including staged records that are not visible to searches, and
+// emits an audit log entry describing what was erased. All of the subject's metadata is first staged for deletion
+// so that it is immediately hidden from reads and so that CollectGarbage will finish the job if the process
+// crashes before the purge completes. The staged blobs are then removed from the blob store, followed by anything
+// else stored under the subject.
+// Subjects with blobs under legal hold cannot be purged and ErrLegalHold is returned before anything is erased.
+//
+// An audit entry is written however the purge ends. If it fails after blobs have been erased, the returned
+// record lists the blobs erased so far along with the error, and the purge can be retried to finish the job.
+func PurgeSubject(ctx context.Context, db MetadataDatabase, store BlobStore, subject string) (purged *SubjectPurgeRecord, err error) {
+	record := SubjectPurgeRecord{
+		Subject:     subject,
+		ErasedBlobs: make([]BlobKey, 0),
+		StartedAt:   time.Now().UTC(),
+	}
+
+	defer func() {
+		writePurgeAuditEntry(ctx, &record, err)
+	}()
+
+	if err := db.StageSubjectBlobMetadataForDeletion(ctx, subject); err != nil {
+		return &record, err
+	}
+
+	for {
+		keys, err := db.GetPageOfSubjectBlobMetadata(ctx, subject)
+		if err != nil {
+			return &record, err
+		}
+
+		if len(keys) == 0 {
+			break
+		}
+
+		for _, key := range keys {
+			if err := deleteBlobAndMetadata(ctx, db, store, key); err != nil {
+				return &record, err
+			}
+
+			record.ErasedBlobs = append(record.ErasedBlobs, key)
+		}
+	}
+
+	// Also erase anything stored for the subject that the database does not refer to, like the partial
+	// content of a failed write. The subject had no blobs under legal hold when its metadata was staged.
+	if err := store.DeleteSubject(ctx, subject); err != nil {
+		return &record, err
+	}
+
+	record.CompletedAt = time.Now().UTC()
+	return &record, nil
+}
+
+func writePurgeAuditEntry(ctx context.Context, record *SubjectPurgeRecord, err error) {
+	erasedIds := make([]string, len(record.ErasedBlobs))
+	for i, key := range record.ErasedBlobs {
+		erasedIds[i] = key.Id.String()
+	}
+
+	event := log.Ctx(ctx).Info()
+	message := "Purged all data for subject"
+	if err != nil {
+		event = log.Ctx(ctx).Error().Err(err)
+		message = "Purge of subject data failed"
+	}
+
+	event = event.
+		Str("audit", "SubjectPurged").
+		Str("subject", record.Subject).
+		Bool("completed", err == nil).
+		Int("erasedBlobCount", len(erasedIds)).
+		Strs("erasedBlobIds", erasedIds).
+		Time("startedAt", record.StartedAt)
+
+	if err == nil {
+		event = event.Time("completedAt", record.CompletedAt)
+	}
+
+	event.Msg(message)
+}
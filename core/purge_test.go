@@ -0,0 +1,47 @@
+package core_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/ismrmrd/mrd-storage-server/core"
+	"github.com/ismrmrd/mrd-storage-server/mocks"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure that blobs erased before a purge fails are returned and recorded in the audit log.
+func TestPurgeSubjectFailureWritesAuditRecord(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+
+	db := mocks.NewMockMetadataDatabase(mockCtrl)
+	store := mocks.NewMockBlobStore(mockCtrl)
+
+	erasedKey := core.BlobKey{Subject: "s", Id: uuid.New()}
+	failedKey := core.BlobKey{Subject: "s", Id: uuid.New()}
+	storeErr := errors.New("storage unavailable")
+
+	db.EXPECT().StageSubjectBlobMetadataForDeletion(gomock.Any(), "s").Return(nil)
+	db.EXPECT().GetPageOfSubjectBlobMetadata(gomock.Any(), "s").Return([]core.BlobKey{erasedKey, failedKey}, nil)
+	store.EXPECT().DeleteBlob(gomock.Any(), erasedKey).Return(nil)
+	db.EXPECT().DeleteBlobMetadata(gomock.Any(), erasedKey).Return(nil)
+	store.EXPECT().DeleteBlob(gomock.Any(), failedKey).Return(storeErr)
+
+	auditLog := bytes.Buffer{}
+	ctx := zerolog.New(&auditLog).WithContext(context.Background())
+
+	record, err := core.PurgeSubject(ctx, db, store, "s")
+	assert.ErrorIs(t, err, storeErr)
+	require.NotNil(t, record)
+	assert.Equal(t, []core.BlobKey{erasedKey}, record.ErasedBlobs)
+
+	assert.Contains(t, auditLog.String(), `"audit":"SubjectPurged"`)
+	assert.Contains(t, auditLog.String(), `"completed":false`)
+	assert.Contains(t, auditLog.String(), erasedKey.Id.String())
+	assert.NotContains(t, auditLog.String(), failedKey.Id.String())
+}
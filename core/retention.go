@@ -0,0 +1,161 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// A server-side rule giving blobs whose tags match all of its filters a time-to-live when the client
+// does not specify an expiration. Filters use FilterOperatorEqual or FilterOperatorPrefix.
+type RetentionPolicy struct {
+	Filters    []TagFilter
+	TimeToLive time.Duration
+}
+
+// Parses retention policies separated by semicolons or newlines. Each policy is a set of tag conditions in
+// query string format, followed by a colon and a duration, e.g. `name=RawData:720h` or
+// `device=test-*&session=s1:24h`. A value ending in `*` matches values with the given prefix.
+func ParseRetentionPolicies(spec string) ([]RetentionPolicy, error) {
+	policies := make([]RetentionPolicy, 0)
+
+	for _, rule := range strings.FieldsFunc(spec, func(r rune) bool { return r == ';' || r == '\n' }) {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		policy, err := parseRetentionPolicy(rule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retention policy '%s': %v", rule, err)
+		}
+
+		policies = append(policies, *policy)
+	}
+
+	return policies, nil
+}
+
+func parseRetentionPolicy(rule string) (*RetentionPolicy, error) {
+	separator := strings.LastIndex(rule, ":")
+	if separator < 0 {
+		return nil, fmt.Errorf("the rule must end with ':' followed by a time-to-live")
+	}
+
+	timeToLive, err := time.ParseDuration(strings.TrimSpace(rule[separator+1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	if timeToLive < 0 {
+		return nil, fmt.Errorf("the time-to-live cannot be negative")
+	}
+
+	conditions, err := url.ParseQuery(strings.TrimSpace(rule[:separator]))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(conditions) == 0 {
+		return nil, fmt.Errorf("at least one tag condition must be given")
+	}
+
+	policy := RetentionPolicy{TimeToLive: timeToLive}
+	for tagName, values := range conditions {
+		if tagName == "" || strings.HasPrefix(tagName, "_") {
+			return nil, fmt.Errorf("invalid tag name '%s'", tagName)
+		}
+
+		for _, value := range values {
+			filter := TagFilter{Tag: strings.ToLower(tagName), Operator: FilterOperatorEqual, Value: value}
+			if strings.HasSuffix(value, "*") {
+				filter.Operator = FilterOperatorPrefix
+				filter.Value = strings.TrimSuffix(value, "*")
+			}
+
+			policy.Filters = append(policy.Filters, filter)
+		}
+	}
+
+	return &policy, nil
+}
+
+// Returns whether a blob with the given key and tags satisfies all of the policy's filters
+func (policy *RetentionPolicy) Matches(key BlobKey, tags *BlobTags) bool {
+	for _, filter := range policy.Filters {
+		var values []string
+		switch filter.Tag {
+		case "subject":
+			values = []string{key.Subject}
+		case "device", "name", "session":
+			if value := systemTagValue(tags, filter.Tag); value != nil {
+				values = []string{*value}
+			}
+		default:
+			values = tags.CustomTags[filter.Tag]
+		}
+
+		if !anyValueMatches(filter, values) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func systemTagValue(tags *BlobTags, tagName string) *string {
+	switch tagName {
+	case "device":
+		return tags.Device
+	case "name":
+		return tags.Name
+	default:
+		return tags.Session
+	}
+}
+
+func anyValueMatches(filter TagFilter, values []string) bool {
+	for _, value := range values {
+		if filter.Operator == FilterOperatorPrefix && strings.HasPrefix(value, filter.Value) ||
+			filter.Operator == FilterOperatorEqual && value == filter.Value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the first of the policies that matches the blob, or nil if none match.
+func FindRetentionPolicy(policies []RetentionPolicy, key BlobKey, tags *BlobTags) *RetentionPolicy {
+	for i := range policies {
+		if policies[i].Matches(key, tags) {
+			return &policies[i]
+		}
+	}
+
+	return nil
+}
+
+// Gives existing blobs without an expiration the time-to-live of the first policy that they match, so that
+// changes to the policies also apply to blobs stored before the change. The time-to-live is counted from
+// appliedAt for blobs created before then, so blobs older than it are not removed right away.
+func ApplyRetentionPolicies(ctx context.Context, db MetadataDatabase, policies []RetentionPolicy, appliedAt time.Time) error {
+	// Because only blobs without an expiration are updated, applying the policies in order
+	// means that a blob matching several policies gets the first one.
+	for _, policy := range policies {
+		count, err := db.ApplyBlobTimeToLive(ctx, &SearchFilter{Tags: policy.Filters}, policy.TimeToLive, appliedAt)
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			log.Ctx(ctx).Info().Msgf("Applied a retention period of %v to %d existing blobs, which will expire no earlier than %v", policy.TimeToLive, count, appliedAt.Add(policy.TimeToLive))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package core_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ismrmrd/mrd-storage-server/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindRetentionPolicyReturnsFirstMatch(t *testing.T) {
+	policies, err := core.ParseRetentionPolicies("name=RawData&device=scanner*:720h\ndevice=test-*:24h;Experiment=a%3Bb:1h")
+	require.Nil(t, err)
+	require.Len(t, policies, 3)
+
+	name := "RawData"
+	testDevice := "test-1"
+	scannerDevice := "scanner-test-1"
+
+	cases := []struct {
+		name     string
+		tags     core.BlobTags
+		expected *time.Duration
+	}{
+		{"all conditions", core.BlobTags{Name: &name, Device: &scannerDevice}, durationPointer(720 * time.Hour)},
+		{"some conditions", core.BlobTags{Name: &name}, nil},
+		{"prefix", core.BlobTags{Name: &name, Device: &testDevice}, durationPointer(24 * time.Hour)},
+		{"custom tag with escaped value", core.BlobTags{CustomTags: map[string][]string{"experiment": {"x", "a;b"}}}, durationPointer(time.Hour)},
+		{"no match", core.BlobTags{CustomTags: map[string][]string{"experiment": {"a"}}}, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			policy := core.FindRetentionPolicy(policies, core.BlobKey{Subject: "s"}, &c.tags)
+			if c.expected == nil {
+				assert.Nil(t, policy)
+			} else {
+				require.NotNil(t, policy)
+				assert.Equal(t, *c.expected, policy.TimeToLive)
+			}
+		})
+	}
+
+	for _, invalid := range []string{"name=RawData", "name=RawData:1x", ":1h", "_ttl=1h:1h", "name=a:-1h"} {
+		_, err := core.ParseRetentionPolicies(invalid)
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func durationPointer(d time.Duration) *time.Duration {
+	return &d
+}
@@ -17,6 +17,8 @@ var (
 	ErrStagedRecordNotFound        = errors.New("staged metadata not found - was StageBlobMetadata() called beforehand?")
 	ErrBlobNotFound                = errors.New("the blob was not found in the store")
 	ErrExistingDatabaseSchemaNewer = errors.New("the existing database schema is newer that what the server supports")
+	ErrVersionMismatch             = errors.New("the blob metadata has been modified since the expected version")
+	ErrLegalHold                   = errors.New("the blob is under legal hold")
 )
 
 type BlobKey struct {
@@ -25,12 +27,14 @@ type BlobKey struct {
 }
 
 type BlobTags struct {
-	Name        *string
-	Device      *string
-	Session     *string
-	ContentType *string
-	TimeToLive  *string
-	CustomTags  map[string][]string
+	Name           *string
+	Device         *string
+	Session        *string
+	ContentType    *string
+	TimeToLive     *string
+	ExpiresAt      *time.Time // an absolute alternative to TimeToLive
+	IdleTimeToLive *string    // the time-to-live that is renewed each time the blob is read
+	CustomTags     map[string][]string
 }
 
 type BlobInfo struct {
@@ -38,27 +42,121 @@ type BlobInfo struct {
 	Tags      BlobTags
 	CreatedAt time.Time
 	ExpiresAt *time.Time
+	Size      *int64
+	Sha256    []byte
+	Version   int64 // incremented each time the metadata is updated
+
+	// If set, the expiration is pushed forward to this long after the blob is read
+	IdleTimeToLive *time.Duration
+
+	// Set while the blob is under legal hold, which keeps it from being deleted or expiring
+	LegalHoldReason *string
+}
+
+// Changes to the tags of an existing blob. Tags that are set replace all of their existing values,
+// and removed tags no longer have a value. Name, Device, and Session are left unchanged when nil.
+type BlobMetadataUpdate struct {
+	Set              BlobTags
+	Remove           []string
+	ExpiresAt        *time.Time // the new expiration time, or nil to leave it unchanged
+	RemoveExpiration bool
+	LegalHoldReason  *string // places the blob under legal hold, or replaces the reason if it already is
+	ReleaseLegalHold bool
 }
 
 type ContinutationToken string
 
+type FilterOperator string
+
+const (
+	FilterOperatorEqual              FilterOperator = "eq"
+	FilterOperatorNotEqual           FilterOperator = "ne"
+	FilterOperatorGreaterThan        FilterOperator = "gt"
+	FilterOperatorGreaterThanOrEqual FilterOperator = "ge"
+	FilterOperatorLessThan           FilterOperator = "lt"
+	FilterOperatorLessThanOrEqual    FilterOperator = "le"
+	FilterOperatorPrefix             FilterOperator = "prefix"
+	FilterOperatorIn                 FilterOperator = "in"
+	FilterOperatorExists             FilterOperator = "exists"
+	FilterOperatorAbsent             FilterOperator = "absent"
+)
+
+type SortKey string
+
+const (
+	SortKeyCreatedAt SortKey = "createdAt"
+	SortKeyName      SortKey = "name"
+	SortKeySize      SortKey = "size"
+	SortKeyExpiresAt SortKey = "expires"
+)
+
+// The order of search results. The zero value orders results from newest to oldest.
+type SortOrder struct {
+	Key       SortKey
+	Ascending bool
+}
+
+// A condition on the value of a system or custom tag
+type TagFilter struct {
+	Tag      string
+	Operator FilterOperator
+	Value    string
+	Values   []string // the candidate values for FilterOperatorIn
+}
+
+// The conditions that blobs returned by a search must satisfy. All conditions must hold.
+type SearchFilter struct {
+	Tags          []TagFilter
+	At            *time.Time
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	LegalHold     *bool // if set, only blobs that are (or are not) under legal hold match
+}
+
 func UnixTimeMsToTime(timeValueMs int64) time.Time {
 	return time.Unix(timeValueMs/1000, (timeValueMs%1000)*1000000)
 }
 
+// The kinds of values that can be listed with GetPageOfDistinctValues
+type DistinctValueKind string
+
+const (
+	DistinctSubjects DistinctValueKind = "subject"
+	DistinctSessions DistinctValueKind = "session"
+	DistinctDevices  DistinctValueKind = "device"
+	DistinctTagNames DistinctValueKind = "tagName"
+)
+
+// The number of blobs that have a given tag value
+type FacetValue struct {
+	Value string
+	Count int64
+}
+
 type MetadataDatabase interface {
 	StageBlobMetadata(ctx context.Context, key BlobKey, tags *BlobTags) (*BlobInfo, error)
-	CompleteStagedBlobMetadata(ctx context.Context, key BlobKey) error
+	CompleteStagedBlobMetadata(ctx context.Context, key BlobKey, size int64, sha256 []byte, createdAt *time.Time) error
+	StageBlobMetadataForDeletion(ctx context.Context, key BlobKey) error
 	DeleteBlobMetadata(ctx context.Context, key BlobKey) error
+	StageSubjectBlobMetadataForDeletion(ctx context.Context, subject string) error
 	GetPageOfExpiredBlobMetadata(ctx context.Context, olderThan time.Time) ([]BlobKey, error)
+	GetPageOfSubjectBlobMetadata(ctx context.Context, subject string) ([]BlobKey, error)
 	GetBlobMetadata(ctx context.Context, key BlobKey, expiresAfter time.Time) (*BlobInfo, error)
-	SearchBlobMetadata(ctx context.Context, tags map[string][]string, at *time.Time, ct *ContinutationToken, pageSize int, expiresAfter time.Time) ([]BlobInfo, *ContinutationToken, error)
+	UpdateBlobMetadata(ctx context.Context, key BlobKey, update *BlobMetadataUpdate, expectedVersions []int64, expiresAfter time.Time) (*BlobInfo, error)
+	ExtendBlobExpiration(ctx context.Context, key BlobKey, expiresAt time.Time) error
+	ApplyBlobTimeToLive(ctx context.Context, filter *SearchFilter, timeToLive time.Duration, appliedAt time.Time) (int64, error)
+	SearchBlobMetadata(ctx context.Context, filter *SearchFilter, sort SortOrder, ct *ContinutationToken, pageSize int, expiresAfter time.Time) ([]BlobInfo, *ContinutationToken, error)
+	CountBlobMetadata(ctx context.Context, filter *SearchFilter, expiresAfter time.Time) (int64, error)
+	GetBlobMetadataFacets(ctx context.Context, filter *SearchFilter, tagNames []string, expiresAfter time.Time) (map[string][]FacetValue, error)
+	GetPageOfDistinctValues(ctx context.Context, kind DistinctValueKind, subject *string, ct *ContinutationToken, pageSize int, expiresAfter time.Time) ([]string, *ContinutationToken, error)
 	HealthCheck(ctx context.Context) error
 }
 
 type BlobStore interface {
 	SaveBlob(ctx context.Context, contents io.Reader, key BlobKey) error
 	ReadBlob(ctx context.Context, writer io.Writer, key BlobKey) error
+	OpenBlob(ctx context.Context, key BlobKey) (io.ReadSeekCloser, error)
 	DeleteBlob(ctx context.Context, key BlobKey) error
+	DeleteSubject(ctx context.Context, subject string) error
 	HealthCheck(ctx context.Context) error
 }
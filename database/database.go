@@ -4,13 +4,16 @@ import (
 	"context"
 	"database/sql"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"path"
+	"regexp"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 	"github.com/ismrmrd/mrd-storage-server/core"
@@ -25,7 +28,12 @@ import (
 const (
 	schemaVersionInitial        = 1
 	schemaVersionAddExpiresAt   = 2
-	schemaVersionLatest         = schemaVersionAddExpiresAt
+	schemaVersionAddSize        = 3
+	schemaVersionAddSha256      = 4
+	schemaVersionAddVersion     = 5
+	schemaVersionAddIdleTtl     = 6
+	schemaVersionAddLegalHold   = 7
+	schemaVersionLatest         = schemaVersionAddLegalHold
 	schemaVersionCompleteStatus = "complete"
 )
 
@@ -43,8 +51,15 @@ type blobMetadata struct {
 	ContentType sql.NullString `gorm:"size:64;"`
 	CreatedAt   int64          `gorm:"autoCreateTime:milli;index:idx_blob_metadata_search,priority:4;index:staged,where:staged = true"`
 	ExpiresAt   sql.NullInt64  `gorm:"index:expires,where:expires_at is not null"`
+	IdleTtl     sql.NullInt64
+	Size        sql.NullInt64
+	Sha256      sql.NullString `gorm:"size:64;"`
+	Version     int64          `gorm:"not null;default:1"`
 	Staged      bool
-	CustomTags  []customBlobMetadata `gorm:"foreignKey:BlobSubject,BlobId;references:Subject,Id;constraint:OnDelete:CASCADE"`
+
+	// Set while the blob is under legal hold. Blobs under legal hold do not expire and cannot be deleted.
+	LegalHoldReason sql.NullString
+	CustomTags      []customBlobMetadata `gorm:"foreignKey:BlobSubject,BlobId;references:Subject,Id;constraint:OnDelete:CASCADE"`
 }
 
 type customBlobMetadata struct {
@@ -54,9 +69,78 @@ type customBlobMetadata struct {
 	TagValue    string    `gorm:"size:64;uniqueindex:idx_custom_blob_metadata_search,priority:4"`
 }
 
+var (
+	numericTagValueRegex = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+	comparisonOperators  = map[core.FilterOperator]string{
+		core.FilterOperatorGreaterThan:        ">",
+		core.FilterOperatorGreaterThanOrEqual: ">=",
+		core.FilterOperatorLessThan:           "<",
+		core.FilterOperatorLessThanOrEqual:    "<=",
+	}
+)
+
+// The condition on expires_at for blobs to be visible, given the time after which they must expire.
+// Blobs under legal hold remain visible after their expiration time has passed.
+const notExpiredCondition = "expires_at > ? OR expires_at is null OR legal_hold_reason is not null"
+
+// The maximum number of values returned for each facet
+const maxFacetValues = 1000
+
+// The sort columns for sort keys other than core.SortKeyCreatedAt
+var sortKeyColumns = map[core.SortKey]string{
+	core.SortKeyName:      "name",
+	core.SortKeySize:      "size",
+	core.SortKeyExpiresAt: "expires_at",
+}
+
 type continuation struct {
-	CreatedTimeMs int64      `json:"ts"`
-	Id            *uuid.UUID `json:"id,omitempty"`
+	CreatedTimeMs int64        `json:"ts"`
+	Id            *uuid.UUID   `json:"id,omitempty"`
+	Ascending     bool         `json:"asc,omitempty"`
+	Sort          core.SortKey `json:"sort,omitempty"` // empty when sorting by creation time
+	StringValue   *string      `json:"sv,omitempty"`
+	IntValue      *int64       `json:"iv,omitempty"`
+}
+
+func (c continuation) matchesSortOrder(sort core.SortOrder) bool {
+	if c.Ascending != sort.Ascending {
+		return false
+	}
+
+	if sort.Key == core.SortKeyCreatedAt {
+		return c.Sort == ""
+	}
+
+	return c.Sort == sort.Key
+}
+
+// Returns the value of the sort column of the last result on the previous page, or nil if it was NULL
+func (c continuation) sortValue() interface{} {
+	if c.StringValue != nil {
+		return *c.StringValue
+	}
+
+	if c.IntValue != nil {
+		return *c.IntValue
+	}
+
+	return nil
+}
+
+func (c *continuation) setSortValue(sort core.SortOrder, blobInfo *core.BlobInfo) {
+	c.Sort = sort.Key
+
+	switch sort.Key {
+	case core.SortKeyName:
+		c.StringValue = blobInfo.Tags.Name
+	case core.SortKeySize:
+		c.IntValue = blobInfo.Size
+	case core.SortKeyExpiresAt:
+		if blobInfo.ExpiresAt != nil {
+			expiresAtMs := blobInfo.ExpiresAt.UnixMilli()
+			c.IntValue = &expiresAtMs
+		}
+	}
 }
 
 type databaseRepository struct {
@@ -137,7 +221,9 @@ func (r databaseRepository) StageBlobMetadata(ctx context.Context, key core.Blob
 		Name:        toNullString(tags.Name),
 		Session:     toNullString(tags.Session),
 		ContentType: toNullString(tags.ContentType),
-		ExpiresAt:   toExpiration(tags.TimeToLive),
+		ExpiresAt:   toExpiration(tags),
+		IdleTtl:     toIdleTimeToLive(tags.IdleTimeToLive),
+		Version:     1,
 		Staged:      true,
 	}
 
@@ -173,14 +259,26 @@ func (r databaseRepository) StageBlobMetadata(ctx context.Context, key core.Blob
 	}
 
 	return &core.BlobInfo{
-		Key:       key,
-		CreatedAt: core.UnixTimeMsToTime(metadata.CreatedAt),
-		ExpiresAt: ExpirationToTime(metadata.ExpiresAt),
-		Tags:      *tags}, nil
+		Key:            key,
+		CreatedAt:      core.UnixTimeMsToTime(metadata.CreatedAt),
+		ExpiresAt:      ExpirationToTime(metadata.ExpiresAt),
+		Version:        metadata.Version,
+		IdleTimeToLive: idleTimeToLiveToDuration(metadata.IdleTtl),
+		Tags:           *tags}, nil
 }
 
-func (r databaseRepository) CompleteStagedBlobMetadata(ctx context.Context, key core.BlobKey) error {
-	res := r.db.WithContext(ctx).Model(&blobMetadata{}).Where("subject = ? AND id = ? AND staged = ?", key.Subject, key.Id, true).Update("staged", false)
+// Marks staged metadata as complete. If createdAt is not nil, it replaces the creation time
+// that was recorded when the metadata was staged.
+func (r databaseRepository) CompleteStagedBlobMetadata(ctx context.Context, key core.BlobKey, size int64, sha256 []byte, createdAt *time.Time) error {
+	updates := map[string]interface{}{"staged": false, "size": size, "sha256": toNullHexString(sha256)}
+	if createdAt != nil {
+		updates["created_at"] = createdAt.UnixMilli()
+	}
+
+	res := r.db.WithContext(ctx).
+		Model(&blobMetadata{}).
+		Where("subject = ? AND id = ? AND staged = ?", key.Subject, key.Id, true).
+		Updates(updates)
 
 	if res.Error != nil {
 		return res.Error
@@ -192,13 +290,64 @@ func (r databaseRepository) CompleteStagedBlobMetadata(ctx context.Context, key
 	return nil
 }
 
+// Returns core.ErrLegalHold if the blob is under legal hold
+func (r databaseRepository) StageBlobMetadataForDeletion(ctx context.Context, key core.BlobKey) error {
+	res := r.db.WithContext(ctx).Model(&blobMetadata{}).
+		Where("subject = ? AND id = ? AND staged = ? AND legal_hold_reason IS NULL", key.Subject, key.Id, false).
+		Update("staged", true)
+
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		var held int64
+		err := r.db.WithContext(ctx).Model(&blobMetadata{}).
+			Where("subject = ? AND id = ? AND staged = ? AND legal_hold_reason IS NOT NULL", key.Subject, key.Id, false).
+			Count(&held).Error
+		if err != nil {
+			return err
+		}
+
+		if held > 0 {
+			return core.ErrLegalHold
+		}
+
+		return core.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Stages all of the subject's blob metadata for deletion. If any of the subject's blobs are under legal hold,
+// nothing is staged and core.ErrLegalHold is returned. Held rows are also excluded from the update itself,
+// since a hold can be placed concurrently under weaker isolation levels.
+func (r databaseRepository) StageSubjectBlobMetadataForDeletion(ctx context.Context, subject string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var held int64
+		err := tx.Model(&blobMetadata{}).
+			Where("subject = ? AND staged = ? AND legal_hold_reason IS NOT NULL", subject, false).
+			Count(&held).Error
+		if err != nil {
+			return err
+		}
+
+		if held > 0 {
+			return fmt.Errorf("%w: %d blobs of subject '%s' are under legal hold", core.ErrLegalHold, held, subject)
+		}
+
+		return tx.Model(&blobMetadata{}).
+			Where("subject = ? AND staged = ? AND legal_hold_reason IS NULL", subject, false).
+			Update("staged", true).Error
+	})
+}
+
 func (r databaseRepository) GetBlobMetadata(ctx context.Context, key core.BlobKey, expiresAfter time.Time) (*core.BlobInfo, error) {
 	query := r.db.WithContext(ctx).
 		Model(&blobMetadata{}).
 		Where("subject = ? AND id = ?", key.Subject, key.Id).
-		Where("expires_at > ? OR expires_at is null", expiresAfter.UnixMilli())
+		Where(notExpiredCondition, expiresAfter.UnixMilli())
 
-	blobs, err := r.readTagsFromMetadataSubquery(ctx, query)
+	blobs, err := r.readTagsFromMetadataSubquery(ctx, query, orderByClause(core.SortOrder{}, "md."))
 
 	if err != nil {
 		return nil, err
@@ -211,6 +360,124 @@ func (r databaseRepository) GetBlobMetadata(ctx context.Context, key core.BlobKe
 	return &blobs[0], nil
 }
 
+// Updates the tags of a visible blob and increments its version. If expectedVersions is not nil and does not contain
+// the current version, the metadata is left unchanged and core.ErrVersionMismatch is returned.
+func (r databaseRepository) UpdateBlobMetadata(ctx context.Context, key core.BlobKey, update *core.BlobMetadataUpdate, expectedVersions []int64, expiresAfter time.Time) (*core.BlobInfo, error) {
+	updates := map[string]interface{}{"version": gorm.Expr("version + 1")}
+	customTagNames := []string{}
+
+	for _, tagName := range update.Remove {
+		switch tagName {
+		case "device", "name", "session":
+			updates[tagName] = nil
+		default:
+			customTagNames = append(customTagNames, strings.ToLower(tagName))
+		}
+	}
+
+	for column, value := range map[string]*string{"device": update.Set.Device, "name": update.Set.Name, "session": update.Set.Session} {
+		if value != nil {
+			updates[column] = *value
+		}
+	}
+
+	if update.RemoveExpiration {
+		updates["expires_at"] = nil
+	} else if update.ExpiresAt != nil {
+		updates["expires_at"] = update.ExpiresAt.UnixMilli()
+	}
+
+	if update.ReleaseLegalHold {
+		updates["legal_hold_reason"] = nil
+	} else if update.LegalHoldReason != nil {
+		updates["legal_hold_reason"] = *update.LegalHoldReason
+	}
+
+	customMetadata := []customBlobMetadata{}
+	for tagName, tagValues := range update.Set.CustomTags {
+		customTagNames = append(customTagNames, strings.ToLower(tagName))
+		for _, tagValue := range tagValues {
+			customMetadata = append(customMetadata, customBlobMetadata{BlobSubject: key.Subject, BlobId: key.Id, TagName: strings.ToLower(tagName), TagValue: tagValue})
+		}
+	}
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		visible := func() *gorm.DB {
+			return tx.Model(&blobMetadata{}).
+				Where("subject = ? AND id = ? AND staged = ?", key.Subject, key.Id, false).
+				Where(notExpiredCondition, expiresAfter.UnixMilli())
+		}
+
+		query := visible()
+		if expectedVersions != nil {
+			query = query.Where("version IN ?", expectedVersions)
+		}
+
+		res := query.Updates(updates)
+		if res.Error != nil {
+			return res.Error
+		}
+
+		if res.RowsAffected == 0 {
+			if expectedVersions != nil {
+				var count int64
+				if err := visible().Count(&count).Error; err != nil {
+					return err
+				}
+
+				if count > 0 {
+					return core.ErrVersionMismatch
+				}
+			}
+
+			return core.ErrRecordNotFound
+		}
+
+		if len(customTagNames) > 0 {
+			err := tx.
+				Where("blob_subject = ? AND blob_id = ? AND tag_name IN ?", key.Subject, key.Id, customTagNames).
+				Delete(&customBlobMetadata{}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(customMetadata) > 0 {
+			return tx.Create(customMetadata).Error
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the update may have set an expiration time in the past
+	return r.GetBlobMetadata(ctx, key, time.Time{})
+}
+
+// Sets the expiration of a visible blob to the given time, unless it already expires later or never expires
+func (r databaseRepository) ExtendBlobExpiration(ctx context.Context, key core.BlobKey, expiresAt time.Time) error {
+	return r.db.WithContext(ctx).
+		Model(&blobMetadata{}).
+		Where("subject = ? AND id = ? AND staged = ? AND expires_at < ?", key.Subject, key.Id, false, expiresAt.UnixMilli()).
+		Update("expires_at", expiresAt.UnixMilli()).Error
+}
+
+// Sets the expiration of visible blobs matching the filter that do not have one and are not under legal hold
+// to the given time after their creation. Returns the number of blobs updated.
+func (r databaseRepository) ApplyBlobTimeToLive(ctx context.Context, filter *core.SearchFilter, timeToLive time.Duration, appliedAt time.Time) (int64, error) {
+	// The time-to-live counts from when the blob was created or from appliedAt, whichever is later,
+	// so that blobs older than the time-to-live are not expired as soon as a policy is added.
+	appliedAtMs := appliedAt.UnixMilli()
+	result := r.applySearchFilter(r.db.WithContext(ctx).Model(&blobMetadata{}), filter).
+		Where("staged = ? AND expires_at IS NULL AND legal_hold_reason IS NULL", false).
+		Update("expires_at", gorm.Expr("CASE WHEN created_at > ? THEN created_at ELSE ? END + ?", appliedAtMs, appliedAtMs, timeToLive.Milliseconds()))
+
+	return result.RowsAffected, result.Error
+}
+
 func (r databaseRepository) DeleteBlobMetadata(ctx context.Context, key core.BlobKey) error {
 	return r.db.WithContext(ctx).Transaction(
 		func(tx *gorm.DB) error {
@@ -230,47 +497,59 @@ func (r databaseRepository) DeleteBlobMetadata(ctx context.Context, key core.Blo
 		})
 }
 
-func (r databaseRepository) SearchBlobMetadata(ctx context.Context, tags map[string][]string, at *time.Time, ct *core.ContinutationToken, pageSize int, expiresAfter time.Time) ([]core.BlobInfo, *core.ContinutationToken, error) {
+func (r databaseRepository) SearchBlobMetadata(ctx context.Context, filter *core.SearchFilter, sort core.SortOrder, ct *core.ContinutationToken, pageSize int, expiresAfter time.Time) ([]core.BlobInfo, *core.ContinutationToken, error) {
 
-	query := r.db.WithContext(ctx).Model(&blobMetadata{})
-
-	for k, values := range tags {
-		switch k {
-		case "subject", "device", "name", "session":
-			for _, v := range values {
-				query = query.Where(fmt.Sprintf("%s = ?", k), v)
-			}
+	if sort.Key == "" {
+		sort.Key = core.SortKeyCreatedAt
+	}
 
-		default:
-			for _, v := range values {
-				query = query.Where("EXISTS (SELECT * FROM custom_blob_metadata WHERE blob_id = id AND blob_subject = subject and tag_name = ? AND tag_value = ?)", k, v)
-			}
-		}
+	sortColumn, hasSortColumn := sortKeyColumns[sort.Key]
+	if sort.Key != core.SortKeyCreatedAt && !hasSortColumn {
+		return nil, nil, fmt.Errorf("unsupported sort key '%s'", sort.Key)
 	}
 
-	query = query.
-		Where("expires_at > ? OR expires_at is null", expiresAfter.UnixMilli()).
-		Order("created_at DESC, id DESC").
+	query := r.filteredBlobMetadata(ctx, filter, expiresAfter).
+		Order(orderByClause(sort, "")).
 		Limit(pageSize + 1)
 
-	if at != nil {
-		query = query.Where("created_at <= ?", at.UnixMilli())
+	comparison := "<"
+	if sort.Ascending {
+		comparison = ">"
 	}
 
 	if ct != nil {
 		c, err := fromContinuationToken(*ct)
-		if err != nil {
+		if err != nil || !c.matchesSortOrder(sort) {
 			return nil, nil, core.ErrInvalidContinuationToken
 		}
 
-		if c.Id == nil {
-			query = query.Where("created_at < ?", c.CreatedTimeMs)
+		if !hasSortColumn {
+			if c.Id == nil {
+				query = query.Where(fmt.Sprintf("created_at %s ?", comparison), c.CreatedTimeMs)
+			} else {
+				query = query.Where(fmt.Sprintf("created_at = ? AND id %[1]s ? OR created_at %[1]s ?", comparison), c.CreatedTimeMs, c.Id, c.CreatedTimeMs)
+			}
 		} else {
-			query = query.Where("created_at = ? AND id < ? OR created_at < ?", c.CreatedTimeMs, c.Id, c.CreatedTimeMs)
+			if c.Id == nil {
+				return nil, nil, core.ErrInvalidContinuationToken
+			}
+
+			tieBreaker := fmt.Sprintf("(created_at %[1]s ? OR created_at = ? AND id %[1]s ?)", comparison)
+			tieBreakerArgs := []interface{}{c.CreatedTimeMs, c.CreatedTimeMs, c.Id}
+
+			value := c.sortValue()
+			if value == nil {
+				// NULL values are last, so only the remaining NULL values can follow
+				query = query.Where(fmt.Sprintf("%s IS NULL AND %s", sortColumn, tieBreaker), tieBreakerArgs...)
+			} else {
+				query = query.Where(
+					fmt.Sprintf("%[1]s %[2]s ? OR %[1]s IS NULL OR %[1]s = ? AND %[3]s", sortColumn, comparison, tieBreaker),
+					append([]interface{}{value, value}, tieBreakerArgs...)...)
+			}
 		}
 	}
 
-	results, err := r.readTagsFromMetadataSubquery(ctx, query)
+	results, err := r.readTagsFromMetadataSubquery(ctx, query, orderByClause(sort, "md."))
 	if err != nil {
 		return nil, nil, err
 	}
@@ -280,15 +559,20 @@ func (r databaseRepository) SearchBlobMetadata(ctx context.Context, tags map[str
 		lastResult, nextResult := results[len(results)-2], results[len(results)-1]
 
 		var c continuation
-		if lastResult.CreatedAt == nextResult.CreatedAt {
+		if hasSortColumn {
+			c = continuation{CreatedTimeMs: lastResult.CreatedAt.UnixMilli(), Id: &lastResult.Key.Id}
+			c.setSortValue(sort, &lastResult)
+		} else if lastResult.CreatedAt == nextResult.CreatedAt {
 			// the timestamp is the same between the last entry of this page and the first entry of the next page
 			// so we will need to include the ID in the continuation token
-			c = continuation{lastResult.CreatedAt.UnixMilli(), &lastResult.Key.Id}
+			c = continuation{CreatedTimeMs: lastResult.CreatedAt.UnixMilli(), Id: &lastResult.Key.Id}
 		} else {
 			// the common path, where we will be able to generate a simpler WHERE clause
-			c = continuation{lastResult.CreatedAt.UnixMilli(), nil}
+			c = continuation{CreatedTimeMs: lastResult.CreatedAt.UnixMilli()}
 		}
 
+		c.Ascending = sort.Ascending
+
 		ct := toContinuationToken(c)
 
 		return results[:pageSize], &ct, err
@@ -297,13 +581,254 @@ func (r databaseRepository) SearchBlobMetadata(ctx context.Context, tags map[str
 	return results, nil, nil
 }
 
+// Returns the ORDER BY clause for the given sort order. Ties are broken by creation time and then by ID,
+// so that the order is stable across pages.
+func orderByClause(sort core.SortOrder, columnPrefix string) string {
+	direction := "DESC"
+	if sort.Ascending {
+		direction = "ASC"
+	}
+
+	clause := fmt.Sprintf("%[1]screated_at %[2]s, %[1]sid %[2]s", columnPrefix, direction)
+
+	if column, ok := sortKeyColumns[sort.Key]; ok {
+		// NULL values are sorted last regardless of the direction. SQLite and PostgreSQL disagree on the default.
+		clause = fmt.Sprintf("CASE WHEN %[1]s%[2]s IS NULL THEN 1 ELSE 0 END, %[1]s%[2]s %[3]s, %[4]s", columnPrefix, column, direction, clause)
+	}
+
+	return clause
+}
+
+// Returns a query on the visible blob metadata records matching the filter
+func (r databaseRepository) filteredBlobMetadata(ctx context.Context, filter *core.SearchFilter, expiresAfter time.Time) *gorm.DB {
+	return r.applySearchFilter(r.db.WithContext(ctx).Model(&blobMetadata{}), filter).
+		Where("staged = ?", false).
+		Where(notExpiredCondition, expiresAfter.UnixMilli())
+}
+
+func (r databaseRepository) CountBlobMetadata(ctx context.Context, filter *core.SearchFilter, expiresAfter time.Time) (int64, error) {
+	var count int64
+	err := r.filteredBlobMetadata(ctx, filter, expiresAfter).Count(&count).Error
+	return count, err
+}
+
+// Returns the most common values of each of the given tags among the blobs matching the filter,
+// in descending order of the number of blobs having the value.
+func (r databaseRepository) GetBlobMetadataFacets(ctx context.Context, filter *core.SearchFilter, tagNames []string, expiresAfter time.Time) (map[string][]core.FacetValue, error) {
+
+	facets := make(map[string][]core.FacetValue, len(tagNames))
+
+	for _, tagName := range tagNames {
+		var query *gorm.DB
+
+		switch tagName {
+		case "subject", "device", "name", "session":
+			query = r.filteredBlobMetadata(ctx, filter, expiresAfter).
+				Select(fmt.Sprintf("%s AS facet_value, COUNT(*) AS facet_count", tagName)).
+				Where(fmt.Sprintf("%s IS NOT NULL", tagName)).
+				Group(tagName)
+
+		default:
+			query = r.db.WithContext(ctx).
+				Table("custom_blob_metadata").
+				Select("tag_value AS facet_value, COUNT(*) AS facet_count").
+				Joins("INNER JOIN (?) AS md ON blob_subject = md.subject AND blob_id = md.id", r.filteredBlobMetadata(ctx, filter, expiresAfter).Select("subject, id")).
+				Where("tag_name = ?", tagName).
+				Group("tag_value")
+		}
+
+		rows, err := query.
+			Order("facet_count DESC, facet_value").
+			Limit(maxFacetValues).
+			Rows()
+
+		if err != nil {
+			return nil, err
+		}
+
+		values := make([]core.FacetValue, 0)
+		for rows.Next() {
+			value := core.FacetValue{}
+			if err := rows.Scan(&value.Value, &value.Count); err != nil {
+				rows.Close()
+				return nil, err
+			}
+
+			values = append(values, value)
+		}
+
+		rows.Close()
+		facets[tagName] = values
+	}
+
+	return facets, nil
+}
+
+// Returns a page of the distinct values of the given kind among visible blobs in ascending order,
+// optionally restricted to a subject.
+func (r databaseRepository) GetPageOfDistinctValues(ctx context.Context, kind core.DistinctValueKind, subject *string, ct *core.ContinutationToken, pageSize int, expiresAfter time.Time) ([]string, *core.ContinutationToken, error) {
+
+	var column string
+	query := r.db.WithContext(ctx).Model(&blobMetadata{})
+
+	switch kind {
+	case core.DistinctSubjects:
+		column = "subject"
+	case core.DistinctSessions:
+		column = "session"
+	case core.DistinctDevices:
+		column = "device"
+	case core.DistinctTagNames:
+		column = "tag_name"
+		query = query.Joins("INNER JOIN custom_blob_metadata ON custom_blob_metadata.blob_subject = subject AND custom_blob_metadata.blob_id = id")
+	default:
+		return nil, nil, fmt.Errorf("unsupported value kind '%s'", kind)
+	}
+
+	query = query.
+		Distinct(column).
+		Where(fmt.Sprintf("%s IS NOT NULL", column)).
+		Where("staged = ?", false).
+		Where(notExpiredCondition, expiresAfter.UnixMilli()).
+		Order(column).
+		Limit(pageSize + 1)
+
+	if subject != nil {
+		query = query.Where("subject = ?", *subject)
+	}
+
+	if ct != nil {
+		c, err := fromValueContinuationToken(*ct)
+		if err != nil {
+			return nil, nil, core.ErrInvalidContinuationToken
+		}
+
+		query = query.Where(fmt.Sprintf("%s > ?", column), c.After)
+	}
+
+	values := make([]string, 0)
+	if err := query.Pluck(column, &values).Error; err != nil {
+		return nil, nil, err
+	}
+
+	if len(values) > pageSize {
+		values = values[:pageSize]
+		nextCt := toValueContinuationToken(valueContinuation{After: values[pageSize-1]})
+		return values, &nextCt, nil
+	}
+
+	return values, nil, nil
+}
+
+func (r databaseRepository) applySearchFilter(query *gorm.DB, filter *core.SearchFilter) *gorm.DB {
+	for _, tagFilter := range filter.Tags {
+		switch tagFilter.Tag {
+		case "subject", "device", "name", "session":
+			switch tagFilter.Operator {
+			case core.FilterOperatorExists:
+				query = query.Where(fmt.Sprintf("%s IS NOT NULL", tagFilter.Tag))
+			case core.FilterOperatorAbsent:
+				query = query.Where(fmt.Sprintf("%s IS NULL", tagFilter.Tag))
+			case core.FilterOperatorNotEqual:
+				// blobs without a value for the tag also do not have the given value
+				predicate, args := r.tagValuePredicate(tagFilter.Tag, tagFilter)
+				query = query.Where(fmt.Sprintf("(%s IS NULL OR NOT (%s))", tagFilter.Tag, predicate), args...)
+			default:
+				predicate, args := r.tagValuePredicate(tagFilter.Tag, tagFilter)
+				query = query.Where(predicate, args...)
+			}
+
+		default:
+			exists := "EXISTS"
+			if tagFilter.Operator == core.FilterOperatorNotEqual || tagFilter.Operator == core.FilterOperatorAbsent {
+				exists = "NOT EXISTS"
+			}
+
+			condition := "tag_name = ?"
+			args := []interface{}{tagFilter.Tag}
+			if tagFilter.Operator != core.FilterOperatorExists && tagFilter.Operator != core.FilterOperatorAbsent {
+				predicate, predicateArgs := r.tagValuePredicate("tag_value", tagFilter)
+				condition = fmt.Sprintf("%s AND %s", condition, predicate)
+				args = append(args, predicateArgs...)
+			}
+
+			query = query.Where(
+				fmt.Sprintf("%s (SELECT * FROM custom_blob_metadata WHERE blob_id = id AND blob_subject = subject AND %s)", exists, condition),
+				args...)
+		}
+	}
+
+	if filter.At != nil {
+		query = query.Where("created_at <= ?", filter.At.UnixMilli())
+	}
+
+	if filter.CreatedAfter != nil {
+		query = query.Where("created_at > ?", filter.CreatedAfter.UnixMilli())
+	}
+
+	if filter.CreatedBefore != nil {
+		query = query.Where("created_at < ?", filter.CreatedBefore.UnixMilli())
+	}
+
+	if filter.LegalHold != nil {
+		if *filter.LegalHold {
+			query = query.Where("legal_hold_reason IS NOT NULL")
+		} else {
+			query = query.Where("legal_hold_reason IS NULL")
+		}
+	}
+
+	return query
+}
+
+// Returns a parameterized SQL predicate applying the filter to the given column.
+// For FilterOperatorNotEqual, the predicate tests for equality and the caller is responsible for negating it.
+func (r databaseRepository) tagValuePredicate(column string, tagFilter core.TagFilter) (string, []interface{}) {
+	switch tagFilter.Operator {
+	case core.FilterOperatorIn:
+		return fmt.Sprintf("%s IN ?", column), []interface{}{tagFilter.Values}
+
+	case core.FilterOperatorPrefix:
+		return fmt.Sprintf("SUBSTR(%s, 1, %d) = ?", column, utf8.RuneCountInString(tagFilter.Value)), []interface{}{tagFilter.Value}
+
+	case core.FilterOperatorGreaterThan, core.FilterOperatorGreaterThanOrEqual, core.FilterOperatorLessThan, core.FilterOperatorLessThanOrEqual:
+		operator := comparisonOperators[tagFilter.Operator]
+		if numericTagValueRegex.MatchString(tagFilter.Value) {
+			// compare numerically, only matching tag values that are numbers
+			return fmt.Sprintf("%s %s CAST(? AS NUMERIC)", r.numericValueExpression(column), operator), []interface{}{tagFilter.Value}
+		}
+
+		return fmt.Sprintf("%s %s ?", column, operator), []interface{}{tagFilter.Value}
+
+	default:
+		return fmt.Sprintf("%s = ?", column), []interface{}{tagFilter.Value}
+	}
+}
+
+// Returns an SQL expression that evaluates to the numeric value of the column, or NULL if the value is not a number.
+func (r databaseRepository) numericValueExpression(column string) string {
+	if r.db.Dialector.Name() == "postgres" {
+		// The CASE ensures that the cast is not evaluated for values that are not numbers.
+		// The pattern avoids '?', which gorm would treat as a parameter placeholder.
+		return fmt.Sprintf(`CASE WHEN %s ~ '^-{0,1}[0-9]+(\.[0-9]+){0,1}$' THEN CAST(%s AS NUMERIC) END`, column, column)
+	}
+
+	// SQLite casts values that are not numbers to 0 instead of failing. The comparison with the
+	// uncast value only holds for numbers, because SQLite converts the text operand to a number
+	// when it is well-formed.
+	return fmt.Sprintf(`CASE WHEN CAST(%s AS NUMERIC) = %s THEN CAST(%s AS NUMERIC) END`, column, column, column)
+}
+
+// Returns a page of keys of staged records older than the given time and of blobs that expired before it.
+// Blobs with an idle time-to-live are included once they have not been read for that long, since reads
+// push their expiration forward. Blobs under legal hold are never included.
 func (r databaseRepository) GetPageOfExpiredBlobMetadata(ctx context.Context, olderThan time.Time) ([]core.BlobKey, error) {
 
 	rows, err := r.db.
 		Model(blobMetadata{}).
 		Select(`subject, id`).
 		Where(`staged = ? AND created_at < ?`, true, olderThan.UnixMilli()).
-		Or(`expires_at < ?`, olderThan.UnixMilli()).
+		Or(`expires_at < ? AND legal_hold_reason IS NULL`, olderThan.UnixMilli()).
 		Limit(200).
 		Rows()
 
@@ -311,12 +836,36 @@ func (r databaseRepository) GetPageOfExpiredBlobMetadata(ctx context.Context, ol
 		return nil, err
 	}
 
+	return scanBlobKeys(rows)
+}
+
+// Returns a page of keys of the given subject's staged records, which include blobs staged for deletion
+// and blobs that are being written. Blobs under legal hold are never staged.
+func (r databaseRepository) GetPageOfSubjectBlobMetadata(ctx context.Context, subject string) ([]core.BlobKey, error) {
+
+	rows, err := r.db.WithContext(ctx).
+		Model(blobMetadata{}).
+		Select(`subject, id`).
+		Where(`subject = ? AND staged = ?`, subject, true).
+		Limit(200).
+		Rows()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return scanBlobKeys(rows)
+}
+
+func scanBlobKeys(rows *sql.Rows) ([]core.BlobKey, error) {
+	defer rows.Close()
+
 	keys := make([]core.BlobKey, 0)
 
 	for rows.Next() {
 		key := core.BlobKey{}
 
-		err = rows.Scan(&key.Subject, &key.Id)
+		err := rows.Scan(&key.Subject, &key.Id)
 		if err != nil {
 			return nil, err
 		}
@@ -327,6 +876,26 @@ func (r databaseRepository) GetPageOfExpiredBlobMetadata(ctx context.Context, ol
 	return keys, nil
 }
 
+// The continuation for listing distinct values, which are returned in ascending order
+type valueContinuation struct {
+	After string `json:"after"`
+}
+
+func toValueContinuationToken(c valueContinuation) core.ContinutationToken {
+	bytes, _ := json.Marshal(c)
+	return core.ContinutationToken(base64.RawURLEncoding.EncodeToString(bytes))
+}
+
+func fromValueContinuationToken(ct core.ContinutationToken) (valueContinuation, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(string(ct))
+	var c valueContinuation
+	if err == nil {
+		err = json.Unmarshal(bytes, &c)
+	}
+
+	return c, err
+}
+
 func toContinuationToken(c continuation) core.ContinutationToken {
 	bytes, _ := json.Marshal(c)
 	return core.ContinutationToken(base64.RawURLEncoding.EncodeToString(bytes))
@@ -342,7 +911,7 @@ func fromContinuationToken(ct core.ContinutationToken) (continuation, error) {
 	return c, err
 }
 
-func (r databaseRepository) readTagsFromMetadataSubquery(ctx context.Context, subquery *gorm.DB) ([]core.BlobInfo, error) {
+func (r databaseRepository) readTagsFromMetadataSubquery(ctx context.Context, subquery *gorm.DB, orderBy string) ([]core.BlobInfo, error) {
 
 	rows, err := r.db.WithContext(ctx).Table("(?) as md", subquery).
 		Select(`md.subject,
@@ -353,13 +922,18 @@ func (r databaseRepository) readTagsFromMetadataSubquery(ctx context.Context, su
 				md.content_type,
 				md.created_at,
 				md.expires_at,
+				md.size,
+				md.sha256,
+				md.version,
+				md.idle_ttl,
+				md.legal_hold_reason,
 				custom_blob_metadata.tag_name,
 				custom_blob_metadata.tag_value`).
 		Joins(`LEFT JOIN custom_blob_metadata
 				ON custom_blob_metadata.blob_subject = md.subject
 				AND custom_blob_metadata.blob_id = md.id`).
 		Where(`md.staged = ?`, false).
-		Order("md.created_at DESC, md.id DESC").
+		Order(orderBy).
 		Rows()
 
 	if err != nil {
@@ -378,6 +952,10 @@ func (r databaseRepository) readTagsFromMetadataSubquery(ctx context.Context, su
 
 		var timeValueMs int64
 		var expirationValueMs sql.NullInt64
+		var size sql.NullInt64
+		var sha256 sql.NullString
+		var idleTtl sql.NullInt64
+		var legalHoldReason sql.NullString
 
 		err = rows.Scan(
 			&tmpBlobInfo.Key.Subject,
@@ -388,6 +966,11 @@ func (r databaseRepository) readTagsFromMetadataSubquery(ctx context.Context, su
 			&tmpBlobInfo.Tags.ContentType,
 			&timeValueMs,
 			&expirationValueMs,
+			&size,
+			&sha256,
+			&tmpBlobInfo.Version,
+			&idleTtl,
+			&legalHoldReason,
 			&customTagName,
 			&customTagValue)
 
@@ -397,6 +980,18 @@ func (r databaseRepository) readTagsFromMetadataSubquery(ctx context.Context, su
 
 		tmpBlobInfo.CreatedAt = core.UnixTimeMsToTime(timeValueMs)
 		tmpBlobInfo.ExpiresAt = ExpirationToTime(expirationValueMs)
+		tmpBlobInfo.IdleTimeToLive = idleTimeToLiveToDuration(idleTtl)
+		if legalHoldReason.Valid {
+			tmpBlobInfo.LegalHoldReason = &legalHoldReason.String
+		}
+		if size.Valid {
+			tmpBlobInfo.Size = &size.Int64
+		}
+		if sha256.Valid {
+			if tmpBlobInfo.Sha256, err = hex.DecodeString(sha256.String); err != nil {
+				return nil, err
+			}
+		}
 
 		if currentBlobInfo == nil || currentBlobInfo.Key.Id != tmpBlobInfo.Key.Id {
 			results = append(results, tmpBlobInfo)
@@ -429,8 +1024,39 @@ func toNullString(stringPointer *string) sql.NullString {
 	return sql.NullString{String: *stringPointer, Valid: true}
 }
 
-func toExpiration(stringPointer *string) sql.NullInt64 {
+func toNullHexString(value []byte) sql.NullString {
+	if value == nil {
+		return sql.NullString{}
+	}
 
+	return sql.NullString{String: hex.EncodeToString(value), Valid: true}
+}
+
+func toExpiration(tags *core.BlobTags) sql.NullInt64 {
+
+	if tags.ExpiresAt != nil {
+		return sql.NullInt64{Int64: tags.ExpiresAt.UnixMilli(), Valid: true}
+	}
+
+	timeToLive := tags.TimeToLive
+	if timeToLive == nil {
+		// blobs with an idle time-to-live expire if they are not read
+		timeToLive = tags.IdleTimeToLive
+	}
+
+	if timeToLive == nil {
+		return sql.NullInt64{}
+	}
+
+	dur, err := time.ParseDuration(*timeToLive)
+	if err != nil {
+		return sql.NullInt64{}
+	}
+
+	return sql.NullInt64{Int64: time.Now().Add(dur).UnixMilli(), Valid: true}
+}
+
+func toIdleTimeToLive(stringPointer *string) sql.NullInt64 {
 	if stringPointer == nil {
 		return sql.NullInt64{}
 	}
@@ -440,7 +1066,16 @@ func toExpiration(stringPointer *string) sql.NullInt64 {
 		return sql.NullInt64{}
 	}
 
-	return sql.NullInt64{Int64: time.Now().Add(dur).UnixMilli(), Valid: true}
+	return sql.NullInt64{Int64: dur.Milliseconds(), Valid: true}
+}
+
+func idleTimeToLiveToDuration(idleTtl sql.NullInt64) *time.Duration {
+	if !idleTtl.Valid {
+		return nil
+	}
+
+	value := time.Duration(idleTtl.Int64) * time.Millisecond
+	return &value
 }
 
 func ExpirationToTime(expiration sql.NullInt64) *time.Time {
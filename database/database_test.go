@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
 	"errors"
 	"path"
@@ -24,7 +25,7 @@ func TestErrorWhenStageBlobMetadataForgotten(t *testing.T) {
 
 	assert.ErrorIs(t, err, core.ErrBlobNotFound)
 
-	err = db.CompleteStagedBlobMetadata(context.Background(), key)
+	err = db.CompleteStagedBlobMetadata(context.Background(), key, 0, nil, nil)
 	assert.ErrorIs(t, err, core.ErrStagedRecordNotFound)
 }
 
@@ -46,13 +47,62 @@ func TestStagedBlobMetadataCleanedUpOnRevert(t *testing.T) {
 
 	_, err = db.StageBlobMetadata(context.Background(), key, &core.BlobTags{})
 	require.Nil(t, err)
-	err = db.CompleteStagedBlobMetadata(context.Background(), key)
+	err = db.CompleteStagedBlobMetadata(context.Background(), key, 0, nil, nil)
 	require.Nil(t, err)
 	blobInfo, err := db.GetBlobMetadata(context.Background(), key, time.Now())
 	require.Nil(t, err)
 	assert.Empty(t, blobInfo.Tags.CustomTags, "Residual custom tags remain after DeleteBlobMetadata call")
 }
 
+func TestMetadataStagedForDeletionIsInvisible(t *testing.T) {
+	db, err := OpenSqliteDatabase(path.Join(t.TempDir(), "x.db"))
+	require.Nil(t, err)
+	key := core.BlobKey{Subject: "a", Id: uuid.New()}
+
+	err = db.StageBlobMetadataForDeletion(context.Background(), key)
+	assert.ErrorIs(t, err, core.ErrRecordNotFound)
+
+	_, err = db.StageBlobMetadata(context.Background(), key, &core.BlobTags{})
+	require.Nil(t, err)
+
+	err = db.StageBlobMetadataForDeletion(context.Background(), key)
+	assert.ErrorIs(t, err, core.ErrRecordNotFound, "A blob that is still being written cannot be deleted")
+
+	err = db.CompleteStagedBlobMetadata(context.Background(), key, 0, nil, nil)
+	require.Nil(t, err)
+
+	err = db.StageBlobMetadataForDeletion(context.Background(), key)
+	require.Nil(t, err)
+
+	_, err = db.GetBlobMetadata(context.Background(), key, time.Now())
+	assert.ErrorIs(t, err, core.ErrRecordNotFound)
+
+	expiredKeys, err := db.GetPageOfExpiredBlobMetadata(context.Background(), time.Now().Add(time.Minute))
+	require.Nil(t, err)
+	assert.Contains(t, expiredKeys, key)
+}
+
+func TestSizeAndHashRecordedWhenStagedMetadataCompleted(t *testing.T) {
+	db, err := OpenSqliteDatabase(path.Join(t.TempDir(), "x.db"))
+	require.Nil(t, err)
+	key := core.BlobKey{Subject: "a", Id: uuid.New()}
+
+	blobInfo, err := db.StageBlobMetadata(context.Background(), key, &core.BlobTags{})
+	require.Nil(t, err)
+	assert.Nil(t, blobInfo.Size)
+	assert.Nil(t, blobInfo.Sha256)
+
+	hash := sha256.Sum256([]byte("hello"))
+	err = db.CompleteStagedBlobMetadata(context.Background(), key, 42, hash[:], nil)
+	require.Nil(t, err)
+
+	blobInfo, err = db.GetBlobMetadata(context.Background(), key, time.Now())
+	require.Nil(t, err)
+	require.NotNil(t, blobInfo.Size)
+	assert.Equal(t, int64(42), *blobInfo.Size)
+	assert.Equal(t, hash[:], blobInfo.Sha256)
+}
+
 func TestExpiredMetadataIsInvisible(t *testing.T) {
 	db, err := OpenSqliteDatabase(path.Join(t.TempDir(), "x.db"))
 	require.Nil(t, err)
@@ -66,7 +116,7 @@ func TestExpiredMetadataIsInvisible(t *testing.T) {
 	_, err = db.StageBlobMetadata(context.Background(), key, &core.BlobTags{TimeToLive: &expiration})
 	require.Nil(t, err)
 
-	err = db.CompleteStagedBlobMetadata(context.Background(), key)
+	err = db.CompleteStagedBlobMetadata(context.Background(), key, 0, nil, nil)
 	require.Nil(t, err)
 
 	blobInfo, err := db.GetBlobMetadata(context.Background(), key, time.Now())
@@ -111,3 +161,115 @@ func TestSchemaVersionUpserted(t *testing.T) {
 	_, err = OpenSqliteDatabase(dbPath)
 	assert.Nil(t, err)
 }
+
+func TestUpdateBlobMetadataChecksVersion(t *testing.T) {
+	db, err := OpenSqliteDatabase(path.Join(t.TempDir(), "x.db"))
+	require.Nil(t, err)
+	key := core.BlobKey{Subject: "a", Id: uuid.New()}
+
+	_, err = db.StageBlobMetadata(context.Background(), key, &core.BlobTags{})
+	require.Nil(t, err)
+
+	update := &core.BlobMetadataUpdate{Set: core.BlobTags{CustomTags: map[string][]string{"approved": {"true"}}}}
+	versions := []int64{1}
+
+	// staged metadata cannot be updated
+	_, err = db.UpdateBlobMetadata(context.Background(), key, update, versions, time.Now())
+	assert.ErrorIs(t, err, core.ErrRecordNotFound)
+
+	require.Nil(t, db.CompleteStagedBlobMetadata(context.Background(), key, 0, nil, nil))
+
+	blobInfo, err := db.UpdateBlobMetadata(context.Background(), key, update, versions, time.Now())
+	require.Nil(t, err)
+	assert.Equal(t, int64(2), blobInfo.Version)
+	assert.Equal(t, []string{"true"}, blobInfo.Tags.CustomTags["approved"])
+
+	_, err = db.UpdateBlobMetadata(context.Background(), key, &core.BlobMetadataUpdate{Remove: []string{"approved"}}, versions, time.Now())
+	assert.ErrorIs(t, err, core.ErrVersionMismatch)
+
+	// the update is made if any of the expected versions matches
+	blobInfo, err = db.UpdateBlobMetadata(context.Background(), key, &core.BlobMetadataUpdate{}, []int64{1, 2, 5}, time.Now())
+	require.Nil(t, err)
+	assert.Equal(t, int64(3), blobInfo.Version)
+
+	blobInfo, err = db.GetBlobMetadata(context.Background(), key, time.Now())
+	require.Nil(t, err)
+	assert.Equal(t, int64(3), blobInfo.Version)
+	assert.Equal(t, []string{"true"}, blobInfo.Tags.CustomTags["approved"])
+}
+
+func TestLegalHoldPreventsDeletionAndExpiration(t *testing.T) {
+	db, err := OpenSqliteDatabase(path.Join(t.TempDir(), "x.db"))
+	require.Nil(t, err)
+	key := core.BlobKey{Subject: "a", Id: uuid.New()}
+
+	_, err = db.StageBlobMetadata(context.Background(), key, &core.BlobTags{})
+	require.Nil(t, err)
+	require.Nil(t, db.CompleteStagedBlobMetadata(context.Background(), key, 0, nil, nil))
+
+	reason := "audit"
+	expiresAt := time.Now().Add(-time.Minute)
+	blobInfo, err := db.UpdateBlobMetadata(context.Background(), key, &core.BlobMetadataUpdate{LegalHoldReason: &reason, ExpiresAt: &expiresAt}, nil, time.Now())
+	require.Nil(t, err)
+	require.NotNil(t, blobInfo.LegalHoldReason)
+	assert.Equal(t, reason, *blobInfo.LegalHoldReason)
+
+	// the blob remains visible after it has expired
+	_, err = db.GetBlobMetadata(context.Background(), key, time.Now())
+	assert.Nil(t, err)
+
+	expiredKeys, err := db.GetPageOfExpiredBlobMetadata(context.Background(), time.Now())
+	require.Nil(t, err)
+	assert.NotContains(t, expiredKeys, key)
+
+	assert.ErrorIs(t, db.StageBlobMetadataForDeletion(context.Background(), key), core.ErrLegalHold)
+
+	assert.ErrorIs(t, db.StageSubjectBlobMetadataForDeletion(context.Background(), key.Subject), core.ErrLegalHold)
+	subjectKeys, err := db.GetPageOfSubjectBlobMetadata(context.Background(), key.Subject)
+	require.Nil(t, err)
+	assert.Empty(t, subjectKeys)
+
+	_, err = db.UpdateBlobMetadata(context.Background(), key, &core.BlobMetadataUpdate{ReleaseLegalHold: true}, nil, time.Now())
+	require.Nil(t, err)
+
+	_, err = db.GetBlobMetadata(context.Background(), key, time.Now())
+	assert.ErrorIs(t, err, core.ErrRecordNotFound)
+
+	expiredKeys, err = db.GetPageOfExpiredBlobMetadata(context.Background(), time.Now())
+	require.Nil(t, err)
+	assert.Contains(t, expiredKeys, key)
+}
+
+func TestApplyBlobTimeToLiveCountsFromWhenAppliedForOlderBlobs(t *testing.T) {
+	db, err := OpenSqliteDatabase(path.Join(t.TempDir(), "x.db"))
+	require.Nil(t, err)
+
+	oldKey := core.BlobKey{Subject: "a", Id: uuid.New()}
+	newKey := core.BlobKey{Subject: "a", Id: uuid.New()}
+	for _, key := range []core.BlobKey{oldKey, newKey} {
+		_, err = db.StageBlobMetadata(context.Background(), key, &core.BlobTags{})
+		require.Nil(t, err)
+		require.Nil(t, db.CompleteStagedBlobMetadata(context.Background(), key, 0, nil, nil))
+	}
+
+	createdAt := time.Now().Add(-48 * time.Hour)
+	require.Nil(t, db.(databaseRepository).db.Model(&blobMetadata{}).
+		Where("subject = ? AND id = ?", oldKey.Subject, oldKey.Id).
+		Update("created_at", createdAt.UnixMilli()).Error)
+
+	// applied in the past, so that it is earlier than when the new blob was created
+	appliedAt := time.Now().Add(-time.Hour)
+	count, err := db.ApplyBlobTimeToLive(context.Background(), &core.SearchFilter{}, 24*time.Hour, appliedAt)
+	require.Nil(t, err)
+	assert.Equal(t, int64(2), count)
+
+	oldBlob, err := db.GetBlobMetadata(context.Background(), oldKey, time.Now())
+	require.Nil(t, err)
+	require.NotNil(t, oldBlob.ExpiresAt)
+	assert.WithinDuration(t, appliedAt.Add(24*time.Hour), *oldBlob.ExpiresAt, time.Second)
+
+	newBlob, err := db.GetBlobMetadata(context.Background(), newKey, time.Now())
+	require.Nil(t, err)
+	require.NotNil(t, newBlob.ExpiresAt)
+	assert.WithinDuration(t, newBlob.CreatedAt.Add(24*time.Hour), *newBlob.ExpiresAt, time.Second)
+}
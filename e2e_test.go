@@ -1,8 +1,15 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -13,8 +20,10 @@ import (
 	"os"
 	"path"
 	"reflect"
+	"sort"
 	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/google/uuid"
@@ -76,13 +85,20 @@ func init() {
 		log.Fatal().Msgf("Unrecognized TEST_STORAGE_PROVIDER environment variable '%s'", storageProvider)
 	}
 
+	config.RetentionPolicies = "retention=short:1h; retention=long*:720h"
+
 	var err error
 	db, blobStore, err = assembleDataStores(config)
 	if err != nil {
 		log.Fatal().Err(err).Send()
 	}
 
-	router = assembleHandler(db, blobStore, config)
+	retentionPolicies, err := core.ParseRetentionPolicies(config.RetentionPolicies)
+	if err != nil {
+		log.Fatal().Err(err).Send()
+	}
+
+	router = assembleHandler(db, blobStore, retentionPolicies, config)
 }
 
 func TestInvalidTags(t *testing.T) {
@@ -96,6 +112,8 @@ func TestInvalidTags(t *testing.T) {
 		{"tag name that is too long", fmt.Sprintf("subject=sub&%s=abc", strings.Repeat("a", 65))},
 		{"Location", "subject=s&location=l"},
 		{"Last-Modified", "subject=s&lastModified=2021-10-18T16:56:15.693Z"},
+		{"Size", "subject=s&size=3"},
+		{"Digest", "subject=s&digest=3"},
 		{"bad ttl", "subject=s&_ttl=not-an-interval"},
 		{"negative ttl", "subject=s&_ttl=-1h"},
 		{"Many Subject tags", "subject=s&subject=s2"},
@@ -238,175 +256,1673 @@ func TestExpiredBlobNotInSearchResults(t *testing.T) {
 	require.Equal(t, http.StatusOK, response.StatusCode)
 	require.Equal(t, 2, len(response.Results.Items))
 
-	for _, item := range response.Results.Items {
-		require.Equal(t, "10m", item["expiration-test"].(string))
+	for _, item := range response.Results.Items {
+		require.Equal(t, "10m", item["expiration-test"].(string))
+	}
+}
+
+func TestExpiredBlobNotFound(t *testing.T) {
+
+	body := "Getting an expired blob directly should return 404"
+	subject := fmt.Sprint(time.Now().UnixNano())
+
+	response := create(
+		t,
+		fmt.Sprintf("subject=%s&session=expiration-lookup-test&_ttl=0s", subject),
+		"text/plain",
+		body)
+
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+	require.Contains(t, response.Meta, "expires")
+
+	time.Sleep(50 * time.Millisecond)
+
+	response = get(t, response.Location)
+
+	require.Equal(t, http.StatusNotFound, response.StatusCode)
+}
+
+func TestBlobCreatedWithTimeToLiveHasExpiration(t *testing.T) {
+
+	body := "This is a body. It will do nicely."
+	subject := fmt.Sprint(time.Now().UnixNano())
+	ttl := "45m"
+
+	createResponse := create(
+		t,
+		fmt.Sprintf("subject=%s&session=mysession&_ttl=%s", subject, ttl),
+		"text/plain",
+		body)
+	require.Equal(t, http.StatusCreated, createResponse.StatusCode)
+
+	readResponse := get(t, createResponse.Location)
+	require.Equal(t, http.StatusOK, readResponse.StatusCode)
+	require.NotNil(t, readResponse.Meta["expires"])
+	require.Equal(t, createResponse.Meta["expires"], readResponse.Meta["expires"])
+}
+
+func TestBlobCreateWithTimeToLiveHasExpirationDataHeader(t *testing.T) {
+	body := "This is a body. Just bytes really."
+	subject := fmt.Sprint(time.Now().UnixNano())
+	ttl := "5m"
+
+	createResponse := create(
+		t,
+		fmt.Sprintf("subject=%s&session=mysession&_ttl=%s", subject, ttl),
+		"text/plain",
+		body)
+	require.Equal(t, http.StatusCreated, createResponse.StatusCode)
+
+	data := read(t, createResponse.Data)
+	require.NotNil(t, data.ExpiresAt)
+}
+
+func TestCreateResponse(t *testing.T) {
+
+	body := "these are some bytes"
+	subject := "$null"
+
+	response := create(t, fmt.Sprintf("subject=%s&session=mysession", subject), "text/plain", body)
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+
+	assert.NotNil(t, response.Meta["lastModified"])
+	assert.Equal(t, "text/plain", response.Meta["contentType"])
+
+	assert.Equal(t, "mysession", response.Meta["session"])
+	assert.Equal(t, "$null", response.Meta["subject"])
+	assert.Nil(t, response.Meta["name"])
+
+	assert.NotNil(t, response.Meta["location"])
+	assert.NotNil(t, response.Meta["data"])
+}
+
+func TestBlobSize(t *testing.T) {
+
+	body := "these are some bytes"
+	subject := fmt.Sprint(time.Now().UnixNano())
+
+	createResponse := create(t, fmt.Sprintf("subject=%s", subject), "text/plain", body)
+	require.Equal(t, http.StatusCreated, createResponse.StatusCode)
+	assert.Equal(t, float64(len(body)), createResponse.Meta["size"])
+
+	metaResponse := get(t, createResponse.Location)
+	require.Equal(t, http.StatusOK, metaResponse.StatusCode)
+	assert.Equal(t, float64(len(body)), metaResponse.Meta["size"])
+
+	searchResponse := search(t, "subject="+subject)
+	require.Len(t, searchResponse.Results.Items, 1)
+	assert.Equal(t, float64(len(body)), searchResponse.Results.Items[0]["size"])
+
+	readResponse := read(t, createResponse.Data)
+	require.Equal(t, http.StatusOK, readResponse.StatusCode)
+	assert.Equal(t, fmt.Sprint(len(body)), readResponse.RawResponse.Header.Get("Content-Length"))
+
+	emptyResponse := create(t, fmt.Sprintf("subject=%s", subject), "text/plain", "")
+	require.Equal(t, http.StatusCreated, emptyResponse.StatusCode)
+	assert.Equal(t, float64(0), emptyResponse.Meta["size"])
+}
+
+func TestBlobDigest(t *testing.T) {
+
+	body := "these are some bytes"
+	subject := fmt.Sprint(time.Now().UnixNano())
+	sha256Hash := sha256.Sum256([]byte(body))
+	md5Hash := md5.Sum([]byte(body))
+	expectedDigest := "sha-256=" + base64.StdEncoding.EncodeToString(sha256Hash[:])
+
+	createResponse := create(t, fmt.Sprintf("subject=%s", subject), "text/plain", body)
+	require.Equal(t, http.StatusCreated, createResponse.StatusCode)
+	assert.Equal(t, expectedDigest, createResponse.Meta["digest"])
+	assert.Equal(t, expectedDigest, get(t, createResponse.Location).Meta["digest"])
+
+	readResponse := read(t, createResponse.Data)
+	require.Equal(t, http.StatusOK, readResponse.StatusCode)
+	assert.Equal(t, fmt.Sprintf(`"%x"`, sha256Hash), readResponse.RawResponse.Header.Get("ETag"))
+	assert.Equal(t, expectedDigest, readResponse.RawResponse.Header.Get("Digest"))
+
+	cases := []struct {
+		name           string
+		headers        http.Header
+		expectedStatus int
+	}{
+		{"matching Content-MD5", http.Header{"Content-Md5": {base64.StdEncoding.EncodeToString(md5Hash[:])}}, http.StatusCreated},
+		{"matching Digest", http.Header{"Digest": {expectedDigest}}, http.StatusCreated},
+		{"matching Digest with several algorithms", http.Header{"Digest": {"UNIXsum=30637, MD5=" + base64.StdEncoding.EncodeToString(md5Hash[:]) + ", " + expectedDigest}}, http.StatusCreated},
+		{"mismatched Content-MD5", http.Header{"Content-Md5": {base64.StdEncoding.EncodeToString(make([]byte, md5.Size))}}, http.StatusBadRequest},
+		{"mismatched Digest", http.Header{"Digest": {"sha-256=" + base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))}}, http.StatusBadRequest},
+		{"malformed Content-MD5", http.Header{"Content-Md5": {"abc"}}, http.StatusBadRequest},
+		{"malformed Digest", http.Header{"Digest": {"sha-256"}}, http.StatusBadRequest},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.headers.Set("Content-Type", "text/plain")
+			resp, err := executeRequest("POST", fmt.Sprintf("/v1/blobs/data?subject=%s&case=%s", subject, gourl.QueryEscape(c.name)), c.headers, strings.NewReader(body))
+			require.Nil(t, err)
+			assert.Equal(t, c.expectedStatus, resp.StatusCode)
+
+			expectedItems := 0
+			if c.expectedStatus == http.StatusCreated {
+				expectedItems = 1
+			}
+			assert.Len(t, search(t, fmt.Sprintf("subject=%s&case=%s", subject, gourl.QueryEscape(c.name))).Results.Items, expectedItems)
+		})
+	}
+}
+
+func TestCreateResponseCustomTags(t *testing.T) {
+
+	body := "these are some bytes"
+	subject := fmt.Sprint(time.Now().UnixNano())
+
+	// Create the blob
+	response := create(
+		t,
+		fmt.Sprintf("subject=%s&session=mysession&customtag1=customTag1Value&customTag2=customTag2Value1&customTag2=customTag2Value2", subject),
+		"text/plain",
+		body)
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+
+	require.Equal(t, "customTag1Value", response.Meta["customtag1"])
+	require.ElementsMatch(t, []string{"customTag2Value1", "customTag2Value2"}, response.Meta["customtag2"])
+}
+
+func TestCreateResponseMatchesBlobMeta(t *testing.T) {
+
+	body := "these are some bytes"
+	subject := "$null"
+
+	createResponse := create(t, fmt.Sprintf("subject=%s&session=mysession", subject), "text/plain", body)
+	require.Equal(t, http.StatusCreated, createResponse.StatusCode)
+
+	readResponse := get(t, createResponse.Location)
+
+	require.Equal(t, http.StatusOK, readResponse.StatusCode)
+	require.True(t, reflect.DeepEqual(createResponse.Meta, readResponse.Meta))
+}
+
+func TestSearchPaging(t *testing.T) {
+
+	subject := fmt.Sprint(time.Now().UnixNano())
+
+	// create several blobs with the same subject
+	totalItems := 10
+	originalQuery := fmt.Sprintf("subject=%s&mytag=t", subject)
+	for i := 0; i < totalItems; i++ {
+		require.Equal(t, http.StatusCreated, create(t, originalQuery, "", "").StatusCode)
+	}
+
+	for _, pageSize := range []int{3, 5, 8, 10, 11} {
+
+		t.Run(fmt.Sprintf("page size %d", pageSize), func(t *testing.T) {
+
+			link := fmt.Sprintf("/v1/blobs?subject=%s&mytag=t&_limit=%d", subject, pageSize)
+
+			items := make(map[string]bool)
+			for link != "" {
+
+				resp := search(t, link[strings.Index(link, "?")+1:])
+
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+				assert.LessOrEqual(t, len(resp.Results.Items), pageSize)
+
+				for _, v := range resp.Results.Items {
+					location := v["location"].(string)
+					assert.NotContains(t, items, location)
+					items[string(location)] = true
+				}
+
+				link = resp.Results.NextLink
+			}
+
+			assert.Equal(t, len(items), totalItems)
+		})
+	}
+
+	// now verify the behavior of the _at parameter for searches and get latest calls
+	fullResults := search(t, originalQuery+"&_limit=0") // <= 0 should be ignored
+	assert.Empty(t, fullResults.Results.NextLink)
+
+	for i := 1; i < len(fullResults.Results.Items); i++ {
+		previousResult := fullResults.Results.Items[i-1]
+		thisResult := fullResults.Results.Items[i]
+		if prevTime, thisTime := previousResult["lastModified"].(string), thisResult["lastModified"].(string); prevTime != thisTime {
+			assert.Regexp(t, "Z$", thisTime, "Datetime in response not in UTC")
+			atQuery := fmt.Sprintf("%s&_at=%s", originalQuery, gourl.QueryEscape(thisTime))
+			atRes := search(t, atQuery)
+
+			require.Equal(t, http.StatusOK, atRes.StatusCode)
+			assert.Equal(t, thisResult["location"].(string), atRes.Results.Items[0]["location"].(string))
+
+			latestResponse := getLatestBlob(t, atQuery)
+			require.Equal(t, http.StatusOK, latestResponse.StatusCode)
+			assert.Equal(t, thisResult["location"].(string), latestResponse.Location)
+		}
+	}
+}
+
+func TestSearchOperators(t *testing.T) {
+	subject := fmt.Sprint(time.Now().UnixNano())
+
+	blobs := []struct {
+		query string
+		name  string
+	}{
+		{"name=NoiseA&device=scannerA&echo=1", "NoiseA"},
+		{"name=NoiseB&device=scannerB&echo=2", "NoiseB"},
+		{"name=Noise%25&echo=3", "Noise%"},
+		{"name=Calibration&device=scannerA&echo=10&approved=yes", "Calibration"},
+		{"name=Other&echo=abc", "Other"},
+	}
+
+	for _, b := range blobs {
+		require.Equal(t, http.StatusCreated, create(t, fmt.Sprintf("subject=%s&%s", subject, b.query), "", "").StatusCode)
+	}
+
+	cases := []struct {
+		query    string
+		expected []string
+	}{
+		{"name:prefix=Noise", []string{"NoiseA", "NoiseB", "Noise%"}},
+		{"name:prefix=noise", []string{}},
+		{"name:prefix=Noise%25", []string{"Noise%"}},
+		{"name:eq=NoiseA", []string{"NoiseA"}},
+		{"device:ne=scannerA", []string{"NoiseB", "Noise%", "Other"}},
+		{"echo:ne=1", []string{"NoiseB", "Noise%", "Calibration", "Other"}},
+		{"echo:gt=2", []string{"Noise%", "Calibration"}},
+		{"echo:ge=2", []string{"NoiseB", "Noise%", "Calibration"}},
+		{"echo:lt=3", []string{"NoiseA", "NoiseB"}},
+		{"echo:le=3&echo:gt=1", []string{"NoiseB", "Noise%"}},
+		{"echo:gt=2.5", []string{"Noise%", "Calibration"}},
+		{"echo:gt=-1&name:prefix=Noise&device:ne=scannerB", []string{"NoiseA", "Noise%"}},
+		{"name:gt=Other", []string{}},
+		{"name:ge=Other", []string{"Other"}},
+		{"name=NoiseA&name=NoiseB", []string{}},
+		{"name:in=NoiseA,Calibration", []string{"NoiseA", "Calibration"}},
+		{"name:in=NoiseA&name:in=Other", []string{"NoiseA", "Other"}},
+		{"name:in=Missing", []string{}},
+		{"device:in=scannerA,scannerB&echo:gt=1", []string{"NoiseB", "Calibration"}},
+		{"echo:in=1,abc", []string{"NoiseA", "Other"}},
+		{"device=$null", []string{"Noise%", "Other"}},
+		{"device:ne=$null", []string{"NoiseA", "NoiseB", "Calibration"}},
+		{"device:absent", []string{"Noise%", "Other"}},
+		{"device:exists", []string{"NoiseA", "NoiseB", "Calibration"}},
+		{"approved:absent&name:prefix=Noise", []string{"NoiseA", "NoiseB", "Noise%"}},
+		{"approved:exists", []string{"Calibration"}},
+		{"echo:absent", []string{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			resp := search(t, fmt.Sprintf("subject=%s&%s", subject, c.query))
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			names := []string{}
+			for _, item := range resp.Results.Items {
+				names = append(names, item["name"].(string))
+			}
+
+			assert.ElementsMatch(t, c.expected, names)
+		})
+	}
+
+	allResults := search(t, "subject="+subject)
+	require.Len(t, allResults.Results.Items, len(blobs))
+	parseLastModified := func(item map[string]interface{}) time.Time {
+		parsed, err := time.Parse(time.RFC3339Nano, item["lastModified"].(string))
+		require.Nil(t, err)
+		return parsed
+	}
+
+	newest := parseLastModified(allResults.Results.Items[0])
+	oldest := parseLastModified(allResults.Results.Items[len(blobs)-1])
+
+	createdAfterResults := search(t, fmt.Sprintf("subject=%s&_createdAfter=%s", subject, gourl.QueryEscape(oldest.Format(time.RFC3339Nano))))
+	require.Equal(t, http.StatusOK, createdAfterResults.StatusCode)
+	for _, item := range createdAfterResults.Results.Items {
+		assert.True(t, parseLastModified(item).After(oldest))
+	}
+
+	createdBeforeResults := search(t, fmt.Sprintf("subject=%s&_createdBefore=%s", subject, gourl.QueryEscape(newest.Format(time.RFC3339Nano))))
+	require.Equal(t, http.StatusOK, createdBeforeResults.StatusCode)
+	for _, item := range createdBeforeResults.Results.Items {
+		assert.True(t, parseLastModified(item).Before(newest))
+	}
+
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339Nano)
+	assert.Empty(t, search(t, fmt.Sprintf("subject=%s&_createdAfter=%s", subject, gourl.QueryEscape(future))).Results.Items)
+	assert.Len(t, search(t, fmt.Sprintf("subject=%s&_createdBefore=%s", subject, gourl.QueryEscape(future))).Results.Items, len(blobs))
+}
+
+func TestSearchSorting(t *testing.T) {
+	subject := fmt.Sprint(time.Now().UnixNano())
+
+	blobs := []struct {
+		query   string
+		content string
+	}{
+		{"name=b&_ttl=2h", "xx"},
+		{"name=a", "x"},
+		{"_ttl=1h", ""},
+		{"name=c&_ttl=3h", "xxxx"},
+		{"name=a&_ttl=1h", "xx"},
+		{"", "xxx"},
+	}
+
+	for _, b := range blobs {
+		require.Equal(t, http.StatusCreated, create(t, fmt.Sprintf("subject=%s&%s", subject, b.query), "", b.content).StatusCode)
+	}
+
+	// returns the value of the field used for sorting, or nil if not set
+	sortValue := func(item map[string]interface{}, sort string) interface{} {
+		switch strings.TrimPrefix(sort, "-") {
+		case "createdAt":
+			return item["lastModified"].(string)
+		case "name":
+			return item["name"]
+		case "size":
+			return item["size"]
+		case "expires":
+			return item["expires"]
+		}
+		return nil
+	}
+
+	compare := func(a, b interface{}) int {
+		switch a := a.(type) {
+		case string:
+			if parsedA, err := time.Parse(time.RFC3339Nano, a); err == nil {
+				parsedB, _ := time.Parse(time.RFC3339Nano, b.(string))
+				return parsedA.Compare(parsedB)
+			}
+			return strings.Compare(a, b.(string))
+		case float64:
+			if a < b.(float64) {
+				return -1
+			}
+			if a > b.(float64) {
+				return 1
+			}
+		}
+		return 0
+	}
+
+	for _, sort := range []string{"createdAt", "-createdAt", "name", "-name", "size", "-size", "expires", "-expires"} {
+		t.Run(sort, func(t *testing.T) {
+			allResults := search(t, fmt.Sprintf("subject=%s&_sort=%s", subject, sort))
+			require.Equal(t, http.StatusOK, allResults.StatusCode)
+			require.Len(t, allResults.Results.Items, len(blobs))
+
+			for i := 1; i < len(allResults.Results.Items); i++ {
+				previous, current := sortValue(allResults.Results.Items[i-1], sort), sortValue(allResults.Results.Items[i], sort)
+				if current == nil {
+					continue
+				}
+
+				// values that are not set are last
+				require.NotNil(t, previous)
+
+				if strings.HasPrefix(sort, "-") {
+					assert.GreaterOrEqual(t, compare(previous, current), 0)
+				} else {
+					assert.LessOrEqual(t, compare(previous, current), 0)
+				}
+			}
+
+			for _, pageSize := range []int{1, 2, 4} {
+				link := fmt.Sprintf("/v1/blobs?subject=%s&_sort=%s&_limit=%d", subject, sort, pageSize)
+				locations := []string{}
+				for link != "" {
+					resp := search(t, link[strings.Index(link, "?")+1:])
+					require.Equal(t, http.StatusOK, resp.StatusCode)
+					for _, item := range resp.Results.Items {
+						locations = append(locations, item["location"].(string))
+					}
+					link = resp.Results.NextLink
+				}
+
+				require.Len(t, locations, len(blobs))
+				for i, item := range allResults.Results.Items {
+					assert.Equal(t, item["location"], locations[i])
+				}
+			}
+		})
+	}
+
+	resp := search(t, fmt.Sprintf("subject=%s&_sort=name&_limit=1", subject))
+	require.NotEmpty(t, resp.Results.NextLink)
+	ct := resp.Results.NextLink[strings.Index(resp.Results.NextLink, "_ct=")+4:]
+	ct = strings.Split(ct, "&")[0]
+
+	// a continuation token can only be used with the sort order it was created for
+	assert.Equal(t, http.StatusBadRequest, search(t, fmt.Sprintf("subject=%s&_sort=-name&_ct=%s", subject, ct)).StatusCode)
+	assert.Equal(t, http.StatusBadRequest, search(t, fmt.Sprintf("subject=%s&_ct=%s", subject, ct)).StatusCode)
+}
+
+func TestCountAndFacets(t *testing.T) {
+	subject := fmt.Sprint(time.Now().UnixNano())
+
+	for _, query := range []string{
+		"name=NoiseCovariance&session=s1&device=scannerA&protocol=p1",
+		"name=NoiseCovariance&session=s1&device=scannerB&protocol=p1&protocol=p2",
+		"name=Calibration&session=s1",
+		"name=NoiseCovariance&session=s2&device=scannerA",
+	} {
+		require.Equal(t, http.StatusCreated, create(t, fmt.Sprintf("subject=%s&%s", subject, query), "", "").StatusCode)
+	}
+
+	expiredResp := create(t, fmt.Sprintf("subject=%s&name=Expired&_ttl=1s", subject), "", "")
+	require.Equal(t, http.StatusCreated, expiredResp.StatusCode)
+	time.Sleep(1100 * time.Millisecond)
+
+	countResp := countBlobs(t, "subject="+subject)
+	require.Equal(t, http.StatusOK, countResp.StatusCode)
+	assert.Equal(t, int64(4), countResp.Result.Count)
+
+	countResp = countBlobs(t, fmt.Sprintf("subject=%s&name=NoiseCovariance&device:exists", subject))
+	require.Equal(t, http.StatusOK, countResp.StatusCode)
+	assert.Equal(t, int64(3), countResp.Result.Count)
+
+	assert.Equal(t, int64(0), countBlobs(t, "subject="+subject+"-missing").Result.Count)
+	assert.Equal(t, http.StatusBadRequest, countBlobs(t, "name=NoiseCovariance").StatusCode)
+
+	facetsResp := blobFacets(t, fmt.Sprintf("subject=%s&_facet=name&_facet=Device&_facet=session&_facet=protocol", subject))
+	require.Equal(t, http.StatusOK, facetsResp.StatusCode)
+	assert.Equal(t, map[string][]api.FacetValue{
+		"name":     {{Value: "NoiseCovariance", Count: 3}, {Value: "Calibration", Count: 1}},
+		"device":   {{Value: "scannerA", Count: 2}, {Value: "scannerB", Count: 1}},
+		"session":  {{Value: "s1", Count: 3}, {Value: "s2", Count: 1}},
+		"protocol": {{Value: "p1", Count: 2}, {Value: "p2", Count: 1}},
+	}, facetsResp.Result.Facets)
+
+	facetsResp = blobFacets(t, fmt.Sprintf("subject=%s&session=s1&_facet=device&_facet=missing", subject))
+	require.Equal(t, http.StatusOK, facetsResp.StatusCode)
+	assert.Equal(t, map[string][]api.FacetValue{
+		"device":  {{Value: "scannerA", Count: 1}, {Value: "scannerB", Count: 1}},
+		"missing": {},
+	}, facetsResp.Result.Facets)
+
+	assert.Equal(t, http.StatusBadRequest, blobFacets(t, "subject="+subject).StatusCode)
+	assert.Equal(t, http.StatusBadRequest, blobFacets(t, fmt.Sprintf("subject=%s&_facet=location", subject)).StatusCode)
+}
+
+func TestDiscovery(t *testing.T) {
+	prefix := fmt.Sprint(time.Now().UnixNano())
+	subject1, subject2 := prefix+"-1", prefix+"-2"
+
+	for _, query := range []string{
+		fmt.Sprintf("subject=%s&session=b&device=x&foo=1", subject1),
+		fmt.Sprintf("subject=%s&session=a&device=x&bar=1&foo=2", subject1),
+		fmt.Sprintf("subject=%s&name=nosession", subject1),
+		fmt.Sprintf("subject=%s&session=c&device=y&baz=1", subject2),
+	} {
+		require.Equal(t, http.StatusCreated, create(t, query, "", "").StatusCode)
+	}
+
+	expiredResp := create(t, fmt.Sprintf("subject=%s&session=expired&device=expired&expired=1&_ttl=1s", subject1), "", "")
+	require.Equal(t, http.StatusCreated, expiredResp.StatusCode)
+	time.Sleep(1100 * time.Millisecond)
+
+	// other tests create subjects as well, so page through all of them
+	for _, pageSize := range []int{1, 2, 100} {
+		subjects := listAll(t, fmt.Sprintf("/v1/subjects?_limit=%d", pageSize))
+		assert.True(t, sort.StringsAreSorted(subjects))
+		assert.Contains(t, subjects, subject1)
+		assert.Contains(t, subjects, subject2)
+	}
+
+	for _, pageSize := range []int{1, 2, 100} {
+		assert.Equal(t, []string{"a", "b"}, listAll(t, fmt.Sprintf("/v1/sessions?subject=%s&_limit=%d", subject1, pageSize)))
+		assert.Equal(t, []string{"bar", "foo"}, listAll(t, fmt.Sprintf("/v1/tags?subject=%s&_limit=%d", subject1, pageSize)))
+	}
+
+	assert.Equal(t, []string{"c"}, listAll(t, "/v1/sessions?subject="+subject2))
+	assert.Equal(t, []string{"x"}, listAll(t, "/v1/devices?subject="+subject1))
+	assert.Equal(t, []string{"baz"}, listAll(t, "/v1/tags?subject="+subject2))
+	assert.Empty(t, listAll(t, "/v1/sessions?subject="+prefix+"-missing"))
+
+	devices := listAll(t, "/v1/devices")
+	assert.Contains(t, devices, "x")
+	assert.Contains(t, devices, "y")
+	assert.NotContains(t, devices, "expired")
+
+	tags := listAll(t, "/v1/tags")
+	assert.Contains(t, tags, "baz")
+	assert.NotContains(t, tags, "expired")
+
+	resp, err := executeRequest("GET", "/v1/sessions", nil, nil)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = executeRequest("GET", "/v1/subjects?_ct=3", nil, nil)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	for _, path := range []string{"/v1/subjects?_sort=name", "/v1/devices?_Fields=name", "/v1/tags?subject=" + subject1 + "&_at=2021-10-19T15:07:17.224Z"} {
+		resp, err = executeRequest("GET", path, nil, nil)
+		require.Nil(t, err)
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode, path)
+		errorResp := api.ErrorResponse{}
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&errorResp))
+		assert.Equal(t, "InvalidParameter", errorResp.Error.Code, path)
+	}
+}
+
+func TestFieldProjection(t *testing.T) {
+	subject := fmt.Sprint(time.Now().UnixNano())
+	query := fmt.Sprintf("subject=%s&name=projection&device=d&mytag=t", subject)
+
+	require.Equal(t, http.StatusCreated, create(t, query, "text/plain", "first").StatusCode)
+	createResp := create(t, query, "text/plain", "second")
+	require.Equal(t, http.StatusCreated, createResp.StatusCode)
+
+	searchResp := search(t, fmt.Sprintf("subject=%s&_fields=name,lastModified&_fields=SIZE,mytag,unknown&_limit=1", subject))
+	require.Equal(t, http.StatusOK, searchResp.StatusCode)
+	require.Len(t, searchResp.Results.Items, 1)
+	assert.Equal(t, map[string]interface{}{
+		"name":         "projection",
+		"lastModified": searchResp.Results.Items[0]["lastModified"],
+		"size":         float64(len("second")),
+		"mytag":        "t",
+	}, searchResp.Results.Items[0])
+
+	// the projection applies to subsequent pages too
+	require.NotEmpty(t, searchResp.Results.NextLink)
+	nextResp := search(t, searchResp.Results.NextLink[strings.Index(searchResp.Results.NextLink, "?")+1:])
+	require.Len(t, nextResp.Results.Items, 1)
+	assert.Equal(t, float64(len("first")), nextResp.Results.Items[0]["size"])
+	assert.NotContains(t, nextResp.Results.Items[0], "location")
+
+	resp, err := executeRequest("GET", createResp.Location+"?_fields=location,subject", nil, nil)
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	metadata := map[string]interface{}{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&metadata))
+	assert.Equal(t, map[string]interface{}{"location": createResp.Location, "subject": subject}, metadata)
+
+	assert.Equal(t, http.StatusBadRequest, search(t, fmt.Sprintf("subject=%s&_fields=", subject)).StatusCode)
+	resp, err = executeRequest("GET", createResp.Location+"?_fields=,", nil, nil)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestNdjsonSearch(t *testing.T) {
+	subject := fmt.Sprint(time.Now().UnixNano())
+
+	for i := 0; i < 3; i++ {
+		require.Equal(t, http.StatusCreated, create(t, fmt.Sprintf("subject=%s&name=n%d", subject, i), "", "").StatusCode)
+	}
+
+	url := fmt.Sprintf("/v1/blobs?subject=%s&_sort=createdAt&_fields=name", subject)
+	resp, err := executeRequest("GET", url, http.Header{"Accept": []string{api.NdjsonContentType}}, nil)
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, api.NdjsonContentType, resp.Header.Get("Content-Type"))
+
+	names := []string{}
+	decoder := json.NewDecoder(resp.Body)
+	for decoder.More() {
+		item := map[string]interface{}{}
+		require.Nil(t, decoder.Decode(&item))
+		assert.Len(t, item, 1)
+		names = append(names, item["name"].(string))
+	}
+
+	assert.Equal(t, []string{"n0", "n1", "n2"}, names)
+
+	resp, err = executeRequest("GET", fmt.Sprintf("/v1/blobs?subject=%s&_ct=3", subject), http.Header{"Accept": []string{api.NdjsonContentType}}, nil)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// _limit only applies to paged responses
+	resp, err = executeRequest("GET", url+"&_Limit=1", http.Header{"Accept": []string{api.NdjsonContentType}}, nil)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	searchResp := search(t, fmt.Sprintf("subject=%s&_limit=1", subject))
+	require.Equal(t, http.StatusOK, searchResp.StatusCode)
+	assert.Len(t, searchResp.Results.Items, 1)
+}
+
+func TestInlineSearch(t *testing.T) {
+	subject := fmt.Sprint(time.Now().UnixNano())
+
+	require.Equal(t, http.StatusCreated, create(t, fmt.Sprintf("subject=%s&name=params", subject), "application/json", `{"te": [2, 4]}`).StatusCode)
+	require.Equal(t, http.StatusCreated, create(t, fmt.Sprintf("subject=%s&name=notes", subject), "text/plain", "hello").StatusCode)
+	require.Equal(t, http.StatusCreated, create(t, fmt.Sprintf("subject=%s&name=invalid", subject), "application/json", "{").StatusCode)
+	require.Equal(t, http.StatusCreated, create(t, fmt.Sprintf("subject=%s&name=large", subject), "text/plain", strings.Repeat("x", 2048)).StatusCode)
+
+	expected := map[string]map[string]interface{}{
+		"params":  {"name": "params", "inlineData": map[string]interface{}{"te": []interface{}{float64(2), float64(4)}}, "inlineEncoding": "json"},
+		"notes":   {"name": "notes", "inlineData": "aGVsbG8=", "inlineEncoding": "base64"},
+		"invalid": {"name": "invalid", "inlineData": "ew==", "inlineEncoding": "base64"},
+		"large":   {"name": "large"},
+	}
+
+	searchResp := search(t, fmt.Sprintf("subject=%s&_include=data&_maxInline=1KiB&_fields=name", subject))
+	require.Equal(t, http.StatusOK, searchResp.StatusCode)
+	require.Len(t, searchResp.Results.Items, len(expected))
+	for _, item := range searchResp.Results.Items {
+		assert.Equal(t, expected[item["name"].(string)], item)
+	}
+
+	url := fmt.Sprintf("/v1/blobs?subject=%s&_include=data&_maxInline=1KiB&_fields=name", subject)
+	resp, err := executeRequest("GET", url, http.Header{"Accept": []string{api.NdjsonContentType}}, nil)
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	decoder := json.NewDecoder(resp.Body)
+	for decoder.More() {
+		item := map[string]interface{}{}
+		require.Nil(t, decoder.Decode(&item))
+		assert.Equal(t, expected[item["name"].(string)], item)
+	}
+
+	searchResp = search(t, fmt.Sprintf("subject=%s&_fields=name", subject))
+	require.Equal(t, http.StatusOK, searchResp.StatusCode)
+	for _, item := range searchResp.Results.Items {
+		assert.NotContains(t, item, "inlineData")
+	}
+
+	searchResp = search(t, fmt.Sprintf("subject=%s&_include=data&_maxInline=lots", subject))
+	assert.Equal(t, http.StatusBadRequest, searchResp.StatusCode)
+}
+
+func TestArchive(t *testing.T) {
+	subject := fmt.Sprint(time.Now().UnixNano())
+
+	expected := map[string]string{}
+	metadata := map[string]map[string]interface{}{}
+	for i, content := range []string{"first", "second", ""} {
+		createResp := create(t, fmt.Sprintf("subject=%s&session=s1&name=n%d", subject, i), "text/plain", content)
+		require.Equal(t, http.StatusCreated, createResp.StatusCode)
+		key := keyFromLocation(t, createResp.Location)
+		expected[api.ArchiveBlobEntryName(key)] = content
+		metadata[api.ArchiveBlobEntryName(key)] = createResp.Meta
+	}
+
+	require.Equal(t, http.StatusCreated, create(t, fmt.Sprintf("subject=%s&session=s2", subject), "text/plain", "other").StatusCode)
+
+	verify := func(t *testing.T, entries map[string][]byte, entryOrder []string) {
+		require.Equal(t, api.ArchiveManifestName, entryOrder[0])
+		manifest := api.SearchResponse{}
+		require.Nil(t, json.Unmarshal(entries[api.ArchiveManifestName], &manifest))
+		require.Len(t, manifest.Items, len(expected))
+		for _, item := range manifest.Items {
+			key := keyFromLocation(t, item["location"].(string))
+			assert.Equal(t, metadata[api.ArchiveBlobEntryName(key)], item)
+		}
+
+		require.Len(t, entries, len(expected)+1)
+		for name, content := range expected {
+			assert.Equal(t, content, string(entries[name]))
+		}
+	}
+
+	query := fmt.Sprintf("subject=%s&session=s1", subject)
+
+	t.Run("tar", func(t *testing.T) {
+		resp, err := executeRequest("GET", "/v1/blobs/archive?"+query, nil, nil)
+		require.Nil(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/x-tar", resp.Header.Get("Content-Type"))
+
+		entries := map[string][]byte{}
+		entryOrder := []string{}
+		reader := tar.NewReader(resp.Body)
+		for {
+			header, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			require.Nil(t, err)
+			entries[header.Name], err = io.ReadAll(reader)
+			require.Nil(t, err)
+			entryOrder = append(entryOrder, header.Name)
+		}
+
+		verify(t, entries, entryOrder)
+	})
+
+	t.Run("zip", func(t *testing.T) {
+		resp, err := executeRequest("GET", "/v1/blobs/archive?"+query+"&_format=zip", nil, nil)
+		require.Nil(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/zip", resp.Header.Get("Content-Type"))
+
+		body, err := io.ReadAll(resp.Body)
+		require.Nil(t, err)
+		reader, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+		require.Nil(t, err)
+
+		entries := map[string][]byte{}
+		entryOrder := []string{}
+		for _, f := range reader.File {
+			entry, err := f.Open()
+			require.Nil(t, err)
+			entries[f.Name], err = io.ReadAll(entry)
+			require.Nil(t, err)
+			entryOrder = append(entryOrder, f.Name)
+		}
+
+		verify(t, entries, entryOrder)
+	})
+
+	resp, err := executeRequest("GET", "/v1/blobs/archive?"+query+"&_format=rar", nil, nil)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = executeRequest("GET", "/v1/blobs/archive?session=s1", nil, nil)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestImportArchive(t *testing.T) {
+	subject := fmt.Sprint(time.Now().UnixNano())
+	query := fmt.Sprintf("subject=%s&session=export", subject)
+
+	originals := map[string]MetaResponse{}
+	for _, tags := range []string{"name=noise&device=d1&series=1&series=2", "name=coils&_ttl=48h"} {
+		createResp := create(t, query+"&"+tags, "text/plain", tags)
+		require.Equal(t, http.StatusCreated, createResp.StatusCode)
+		originals[createResp.Meta["name"].(string)] = createResp
+	}
+
+	// export both formats before importing, which adds blobs matching the query
+	archives := map[string][]byte{}
+	for _, format := range []string{"tar", "zip"} {
+		resp, err := executeRequest("GET", "/v1/blobs/archive?"+query+"&_format="+format, nil, nil)
+		require.Nil(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		archives[resp.Header.Get("Content-Type")], err = io.ReadAll(resp.Body)
+		require.Nil(t, err)
+	}
+
+	for contentType, archive := range archives {
+		t.Run(contentType, func(t *testing.T) {
+			resp, err := executeRequest("POST", "/v1/blobs/archive", http.Header{"Content-Type": []string{contentType}}, bytes.NewReader(archive))
+			require.Nil(t, err)
+			require.Equal(t, http.StatusCreated, resp.StatusCode)
+			importResp := api.SearchResponse{}
+			require.Nil(t, json.NewDecoder(resp.Body).Decode(&importResp))
+			require.Len(t, importResp.Items, len(originals))
+
+			for _, item := range importResp.Items {
+				original := originals[item["name"].(string)]
+				assert.NotEqual(t, original.Location, item["location"])
+				for field, value := range original.Meta {
+					if field != "location" && field != "data" && field != "expires" {
+						assert.Equal(t, value, item[field], field)
+					}
+				}
+				assert.Len(t, item, len(original.Meta))
+			}
+
+			searchResp := search(t, query+"&name=noise")
+			require.Equal(t, http.StatusOK, searchResp.StatusCode)
+			for _, item := range searchResp.Results.Items {
+				assert.Equal(t, "name=noise&device=d1&series=1&series=2", read(t, item["data"].(string)).Body)
+			}
+		})
+	}
+
+	// digest mismatches, missing blobs, and unknown files are all rejected without importing anything
+	exportResp, err := executeRequest("GET", "/v1/blobs/archive?"+query+"&name=coils", nil, nil)
+	require.Nil(t, err)
+	exportReader := tar.NewReader(exportResp.Body)
+	header, err := exportReader.Next()
+	require.Nil(t, err)
+	manifest, err := io.ReadAll(exportReader)
+	require.Nil(t, err)
+	header, err = exportReader.Next()
+	require.Nil(t, err)
+	blobEntryName := header.Name
+
+	createTar := func(entries ...string) io.Reader {
+		buf := &bytes.Buffer{}
+		writer := tar.NewWriter(buf)
+		for i := 0; i < len(entries); i += 2 {
+			require.Nil(t, writer.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: entries[i], Size: int64(len(entries[i+1])), Mode: 0644}))
+			_, err := writer.Write([]byte(entries[i+1]))
+			require.Nil(t, err)
+		}
+		require.Nil(t, writer.Close())
+		return buf
+	}
+
+	countBefore := countBlobs(t, query).Result.Count
+
+	for _, invalid := range []struct {
+		desc    string
+		archive io.Reader
+	}{
+		{"digest mismatch", createTar(api.ArchiveManifestName, string(manifest), blobEntryName, "tampered")},
+		{"missing blob", createTar(api.ArchiveManifestName, string(manifest))},
+		{"unknown file", createTar(api.ArchiveManifestName, string(manifest), blobEntryName, "name=coils&_ttl=48h", "blobs/other", "")},
+		{"manifest not first", createTar(blobEntryName, "name=coils&_ttl=48h", api.ArchiveManifestName, string(manifest))},
+		{"invalid manifest", createTar(api.ArchiveManifestName, `{"items": [{"subject": "s"}]}`)},
+	} {
+		resp, err := executeRequest("POST", "/v1/blobs/archive", http.Header{"Content-Type": []string{"application/x-tar"}}, invalid.archive)
+		require.Nil(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, invalid.desc)
+	}
+
+	assert.Equal(t, countBefore, countBlobs(t, query).Result.Count)
+
+	resp, err := executeRequest("POST", "/v1/blobs/archive", http.Header{"Content-Type": []string{"application/json"}}, strings.NewReader("{}"))
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+}
+
+func TestUnsupportedSearchOptionsRejected(t *testing.T) {
+	subject := fmt.Sprint(time.Now().UnixNano())
+	require.Equal(t, http.StatusCreated, create(t, fmt.Sprintf("subject=%s&name=n", subject), "text/plain", "").StatusCode)
+
+	for _, c := range []struct{ method, path, supported, unsupported string }{
+		{"GET", "/v1/blobs", "_fields=name", "_field=name"},
+		{"GET", "/v1/blobs", "_sort=name", "_format=zip"},
+		{"GET", "/v1/blobs/count", "_at=2100-01-01T00:00:00Z", "_fields=name"},
+		{"GET", "/v1/blobs/facets", "_facet=name", "_sort=name"},
+		{"GET", "/v1/blobs/latest", "_redirect=false", "_sort=name"},
+		{"GET", "/v1/blobs/data/latest", "_limit=1", "_redirect"},
+		{"GET", "/v1/blobs/archive", "_format=zip", "_include=data"},
+		{"DELETE", "/v1/blobs", "_dryRun", "_dryrun=true&_force"},
+	} {
+		query := fmt.Sprintf("?subject=%s&name=n&", subject)
+
+		resp, err := executeRequest(c.method, c.path+query+c.supported, nil, nil)
+		require.Nil(t, err)
+		assert.Less(t, resp.StatusCode, 300, c)
+
+		resp, err = executeRequest(c.method, c.path+query+c.unsupported, nil, nil)
+		require.Nil(t, err)
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode, c)
+		errorResp := api.ErrorResponse{}
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&errorResp))
+		assert.Equal(t, "InvalidParameter", errorResp.Error.Code, c)
+	}
+
+	assert.Equal(t, int64(1), countBlobs(t, "subject="+subject).Result.Count)
+}
+
+func TestImportArchiveKeepsLegalHold(t *testing.T) {
+	subject := fmt.Sprint(time.Now().UnixNano())
+	held := create(t, fmt.Sprintf("subject=%s&name=evidence&_ttl=1h", subject), "text/plain", "evidence")
+	require.Equal(t, http.StatusCreated, held.StatusCode)
+
+	resp, err := executeRequest("PUT", held.Location+"/hold", http.Header{"Content-Type": []string{"application/json"}}, strings.NewReader(`{"reason": "Audit"}`))
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = executeRequest("GET", "/v1/blobs/archive?subject="+subject, nil, nil)
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	archive, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+
+	resp, err = executeRequest("POST", "/v1/blobs/archive", http.Header{"Content-Type": []string{"application/x-tar"}}, bytes.NewReader(archive))
+	require.Nil(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	importResp := api.SearchResponse{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&importResp))
+	require.Len(t, importResp.Items, 1)
+
+	imported := get(t, importResp.Items[0]["location"].(string)).Meta
+	assert.Equal(t, true, imported["legalHold"])
+	assert.Equal(t, "Audit", imported["legalHoldReason"])
+	assert.Equal(t, get(t, held.Location).Meta["expires"], imported["expires"])
+
+	for _, location := range []string{held.Location, importResp.Items[0]["location"].(string)} {
+		assert.Equal(t, http.StatusConflict, deleteBlob(t, location).StatusCode)
+		resp, err = executeRequest("DELETE", location+"/hold", nil, nil)
+		require.Nil(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestGetLatestBlobMetadata(t *testing.T) {
+	subject := fmt.Sprint(time.Now().UnixNano())
+	query := fmt.Sprintf("subject=%s&name=calibration", subject)
+
+	require.Equal(t, http.StatusCreated, create(t, query, "text/plain", "older").StatusCode)
+	latestResp := create(t, query, "text/plain", "newer")
+	require.Equal(t, http.StatusCreated, latestResp.StatusCode)
+
+	resp, err := executeRequest("GET", "/v1/blobs/latest?"+query, nil, nil)
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, latestResp.Location, resp.Header.Get("Location"))
+	metadata := map[string]interface{}{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&metadata))
+	assert.Equal(t, latestResp.Meta, metadata)
+
+	resp, err = executeRequest("GET", "/v1/blobs/latest?"+query+"&_fields=size", nil, nil)
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	metadata = map[string]interface{}{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&metadata))
+	assert.Equal(t, map[string]interface{}{"size": float64(len("newer"))}, metadata)
+
+	for _, redirect := range []string{"_redirect", "_redirect=true"} {
+		resp, err = executeRequest("GET", "/v1/blobs/latest?"+query+"&"+redirect, nil, nil)
+		require.Nil(t, err)
+		require.Equal(t, http.StatusSeeOther, resp.StatusCode)
+		assert.Equal(t, latestResp.Data, resp.Header.Get("Location"))
+	}
+
+	assert.Equal(t, "newer", read(t, latestResp.Data).Body)
+
+	resp, err = executeRequest("GET", "/v1/blobs/latest?"+query+"&_redirect=false", nil, nil)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = executeRequest("GET", "/v1/blobs/latest?"+query+"&_redirect=maybe", nil, nil)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = executeRequest("GET", "/v1/blobs/latest?"+query+"-missing&_redirect", nil, nil)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestBatchGetLatest(t *testing.T) {
+	subject := fmt.Sprint(time.Now().UnixNano())
+
+	require.Equal(t, http.StatusCreated, create(t, fmt.Sprintf("subject=%s&name=noise", subject), "application/json", `{"old":true}`).StatusCode)
+	noiseResp := create(t, fmt.Sprintf("subject=%s&name=noise", subject), "application/json", `{"scale": 2}`)
+	require.Equal(t, http.StatusCreated, noiseResp.StatusCode)
+	coilResp := create(t, fmt.Sprintf("subject=%s&name=coils&device=scanner1", subject), "application/octet-stream", "\x00\x01\x02")
+	require.Equal(t, http.StatusCreated, coilResp.StatusCode)
+
+	body := fmt.Sprintf(`[
+		{"subject": "%[1]s", "name": "noise"},
+		{"subject": "%[1]s", "name": ["coils"], "device:in": "scanner1,scanner2"},
+		{"subject": "%[1]s", "name": "b0map"}
+	]`, subject)
+
+	batch := func(queryString, body string) (*http.Response, BatchResponse) {
+		resp, err := executeRequest("POST", "/v1/blobs/latest?"+queryString, nil, strings.NewReader(body))
+		require.Nil(t, err)
+		batchResp := BatchResponse{}
+		if resp.StatusCode == http.StatusOK {
+			require.Nil(t, json.NewDecoder(resp.Body).Decode(&batchResp))
+		}
+		return resp, batchResp
+	}
+
+	resp, batchResp := batch("", body)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, batchResp.Results, 3)
+	assert.Equal(t, http.StatusOK, batchResp.Results[0].Status)
+	assert.Equal(t, noiseResp.Meta, batchResp.Results[0].Item)
+	assert.Equal(t, http.StatusOK, batchResp.Results[1].Status)
+	assert.Equal(t, coilResp.Meta, batchResp.Results[1].Item)
+	assert.Equal(t, http.StatusNotFound, batchResp.Results[2].Status)
+	assert.Nil(t, batchResp.Results[2].Item)
+
+	resp, batchResp = batch("_include=data&_fields=name", body)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, map[string]interface{}{"name": "noise", "inlineData": map[string]interface{}{"scale": float64(2)}, "inlineEncoding": "json"}, batchResp.Results[0].Item)
+	assert.Equal(t, map[string]interface{}{"name": "coils", "inlineData": "AAEC", "inlineEncoding": "base64"}, batchResp.Results[1].Item)
+
+	resp, batchResp = batch("_include=data&_maxInline=2B&_fields=name", body)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, map[string]interface{}{"name": "noise"}, batchResp.Results[0].Item)
+	assert.Equal(t, map[string]interface{}{"name": "coils"}, batchResp.Results[1].Item)
+
+	for _, invalid := range []struct{ queryString, body string }{
+		{"", "{}"},
+		{"", "[]"},
+		{"", `[{"subject": 1}]`},
+		{"", `[{"name": "noise"}]`},
+		{"_include=metadata", body},
+		{"_maxInline=1KiB", body},
+		{"_include=data&_maxInline=2MiB", body},
+	} {
+		resp, _ := batch(invalid.queryString, invalid.body)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, invalid)
+	}
+
+	// options that do not apply to a single query are rejected rather than ignored
+	for _, option := range []string{`"_limit": "1"`, `"_ct": "x"`, `"_sort": "name"`, `"_include": "data"`, `"_unknown": "x"`} {
+		resp, _ := batch("", fmt.Sprintf(`[{"subject": "%s"}, {"subject": "%s", %s}]`, subject, subject, option))
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode, option)
+		errorResp := api.ErrorResponse{}
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&errorResp))
+		assert.Equal(t, "InvalidParameter", errorResp.Error.Code, option)
+		assert.True(t, strings.HasPrefix(errorResp.Error.Message, "Query 1: "), errorResp.Error.Message)
+	}
+
+	resp, batchResp = batch("", fmt.Sprintf(`[{"subject": "%s", "name": "noise", "_createdBefore": "2000-01-01T00:00:00Z"}]`, subject))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, http.StatusNotFound, batchResp.Results[0].Status)
+}
+
+func TestUpdateBlobMetadata(t *testing.T) {
+	subject := fmt.Sprint(time.Now().UnixNano())
+	createResp := create(t, fmt.Sprintf("subject=%s&session=mistyped&name=recon&reviewer=a&series=1&series=2", subject), "text/plain", "content")
+	require.Equal(t, http.StatusCreated, createResp.StatusCode)
+
+	patch := func(body string, headers http.Header) (*http.Response, map[string]interface{}) {
+		if headers == nil {
+			headers = http.Header{}
+		}
+		headers.Set("Content-Type", api.MergePatchContentType)
+		resp, err := executeRequest("PATCH", createResp.Location, headers, strings.NewReader(body))
+		require.Nil(t, err)
+		metadata := map[string]interface{}{}
+		if resp.StatusCode == http.StatusOK {
+			require.Nil(t, json.NewDecoder(resp.Body).Decode(&metadata))
+		}
+		return resp, metadata
+	}
+
+	resp, err := executeRequest("GET", createResp.Location, nil, nil)
+	require.Nil(t, err)
+	etag := resp.Header.Get("ETag")
+	require.NotEmpty(t, etag)
+
+	resp, metadata := patch(`{"session": "fixed", "approved": "true", "reviewer": null, "series": ["3"], "name": null}`, http.Header{"If-Match": []string{etag}})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	newEtag := resp.Header.Get("ETag")
+	assert.NotEqual(t, etag, newEtag)
+	assert.Equal(t, "fixed", metadata["session"])
+	assert.Equal(t, "true", metadata["approved"])
+	assert.Equal(t, "3", metadata["series"])
+	assert.NotContains(t, metadata, "reviewer")
+	assert.NotContains(t, metadata, "name")
+	assert.Equal(t, createResp.Meta["lastModified"], metadata["lastModified"])
+	assert.Equal(t, createResp.Meta["digest"], metadata["digest"])
+	assert.Equal(t, metadata, get(t, createResp.Location).Meta)
+
+	searchResp := search(t, fmt.Sprintf("subject=%s&session=fixed&approved=true&name=$null", subject))
+	require.Equal(t, http.StatusOK, searchResp.StatusCode)
+	assert.Len(t, searchResp.Results.Items, 1)
+
+	// the old ETag no longer matches
+	resp, _ = patch(`{"approved": "false"}`, http.Header{"If-Match": []string{etag}})
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	assert.Equal(t, "true", get(t, createResp.Location).Meta["approved"])
+
+	// any of the listed ETags can match
+	resp, _ = patch(`{"approved": "true"}`, http.Header{"If-Match": []string{etag + ", " + newEtag}})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = patch(`{"approved": "true"}`, http.Header{"If-Match": []string{etag, newEtag}})
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+
+	resp, _ = patch(`{"approved": "false"}`, http.Header{"If-Match": []string{"*"}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, metadata = patch(`{"approved": []}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotContains(t, metadata, "approved")
+
+	assert.Equal(t, "content", read(t, createResp.Data).Body)
+
+	for _, invalid := range []string{
+		`{"subject": "other"}`,
+		`{"lastModified": "2021-01-01T00:00:00Z"}`,
+		`{"location": null}`,
+		`{"_ttl": "-1h"}`,
+		`{"_ttl": "1h", "expires": null}`,
+		`{"expires": "2000-01-01T00:00:00Z"}`,
+		`{"name": ["a", "b"]}`,
+		`{"approved": 1}`,
+		`{"invalid tag": "x"}`,
+		`["approved"]`,
+	} {
+		resp, _ := patch(invalid, nil)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, invalid)
+	}
+
+	resp, err = executeRequest("PATCH", createResp.Location, http.Header{"Content-Type": []string{"text/plain"}}, strings.NewReader(`{}`))
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+
+	resp, err = executeRequest("PATCH", createResp.Location+"-missing", http.Header{"Content-Type": []string{api.MergePatchContentType}}, strings.NewReader(`{}`))
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestChangeExpiration(t *testing.T) {
+	subject := fmt.Sprint(time.Now().UnixNano())
+	createResp := create(t, fmt.Sprintf("subject=%s&_ttl=1h", subject), "text/plain", "content")
+	require.Equal(t, http.StatusCreated, createResp.StatusCode)
+
+	patch := func(body string) map[string]interface{} {
+		resp, err := executeRequest("PATCH", createResp.Location, http.Header{"Content-Type": []string{api.MergePatchContentType}}, strings.NewReader(body))
+		require.Nil(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode, body)
+		metadata := map[string]interface{}{}
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&metadata))
+		return metadata
+	}
+
+	parseExpires := func(metadata map[string]interface{}) time.Time {
+		expires, err := time.Parse(time.RFC3339Nano, metadata["expires"].(string))
+		require.Nil(t, err)
+		return expires
+	}
+
+	metadata := patch(`{"_ttl": "48h"}`)
+	assert.WithinDuration(t, time.Now().Add(48*time.Hour), parseExpires(metadata), time.Minute)
+
+	metadata = patch(`{"expires": null}`)
+	assert.NotContains(t, metadata, "expires")
+	assert.NotContains(t, get(t, createResp.Location).Meta, "expires")
+
+	expiresAt := time.Now().Add(72 * time.Hour).UTC().Truncate(time.Millisecond)
+	metadata = patch(fmt.Sprintf(`{"expires": "%s"}`, expiresAt.Format(time.RFC3339Nano)))
+	assert.True(t, expiresAt.Equal(parseExpires(metadata)))
+
+	// shortening the time-to-live can make the blob expire
+	patch(`{"_ttl": "0s"}`)
+	resp, err := executeRequest("GET", createResp.Location, nil, nil)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	createResp = create(t, fmt.Sprintf("subject=%s&_expiresAt=%s", subject, gourl.QueryEscape(expiresAt.Format(time.RFC3339Nano))), "text/plain", "content")
+	require.Equal(t, http.StatusCreated, createResp.StatusCode)
+	assert.True(t, expiresAt.Equal(parseExpires(createResp.Meta)))
+
+	for _, invalid := range []string{
+		"_expiresAt=2000-01-01T00:00:00Z",
+		"_expiresAt=tomorrow",
+		"_expiresAt=" + gourl.QueryEscape(expiresAt.Format(time.RFC3339Nano)) + "&_ttl=1h",
+	} {
+		assert.Equal(t, http.StatusBadRequest, create(t, fmt.Sprintf("subject=%s&%s", subject, invalid), "text/plain", "").StatusCode, invalid)
+	}
+}
+
+func TestIdleTimeToLive(t *testing.T) {
+	subject := fmt.Sprint(time.Now().UnixNano())
+	createResp := create(t, fmt.Sprintf("subject=%s&name=cached&_idleTtl=1h", subject), "text/plain", "content")
+	require.Equal(t, http.StatusCreated, createResp.StatusCode)
+	assert.Equal(t, "1h0m0s", createResp.Meta["idleTtl"])
+
+	parseExpires := func(metadata map[string]interface{}) time.Time {
+		expires, err := time.Parse(time.RFC3339Nano, metadata["expires"].(string))
+		require.Nil(t, err)
+		return expires
+	}
+
+	assert.WithinDuration(t, time.Now().Add(time.Hour), parseExpires(createResp.Meta), time.Minute)
+
+	latestQuery := fmt.Sprintf("subject=%s&name=cached", subject)
+	reads := []struct {
+		name    string
+		method  string
+		url     string
+		headers http.Header
+		body    string
+		refresh bool
+	}{
+		{"data", "GET", createResp.Data, nil, "", true},
+		{"metadata", "GET", createResp.Location, nil, "", true},
+		{"latest data", "GET", "/v1/blobs/data/latest?" + latestQuery, nil, "", true},
+		{"latest metadata", "GET", "/v1/blobs/latest?" + latestQuery, nil, "", true},
+		{"batch latest", "POST", "/v1/blobs/latest", nil, fmt.Sprintf(`[{"subject": "%s", "name": "cached"}]`, subject), true},
+		{"inlined search", "GET", "/v1/blobs?_include=data&" + latestQuery, nil, "", true},
+		{"archive", "GET", "/v1/blobs/archive?" + latestQuery, nil, "", true},
+		{"search", "GET", "/v1/blobs?" + latestQuery, nil, "", false},
+		{"not modified", "GET", createResp.Data, http.Header{"If-None-Match": []string{"*"}}, "", false},
+	}
+
+	for _, c := range reads {
+		// simulate the blob not having been read for 50 minutes
+		resp, err := executeRequest("PATCH", createResp.Location, http.Header{"Content-Type": []string{api.MergePatchContentType}}, strings.NewReader(`{"_ttl": "10m"}`))
+		require.Nil(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp, err = executeRequest(c.method, c.url, c.headers, strings.NewReader(c.body))
+		require.Nil(t, err)
+		io.Copy(io.Discard, resp.Body)
+		require.Less(t, resp.StatusCode, 400, c.name)
+		if c.headers != nil {
+			require.Equal(t, http.StatusNotModified, resp.StatusCode)
+		}
+
+		resp, err = executeRequest("GET", "/v1/blobs?subject="+subject, nil, nil)
+		require.Nil(t, err)
+		searchResp := api.SearchResponse{}
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&searchResp))
+		require.Len(t, searchResp.Items, 1)
+
+		expected := time.Now().Add(10 * time.Minute)
+		if c.refresh {
+			expected = time.Now().Add(time.Hour)
+		}
+
+		assert.WithinDuration(t, expected, parseExpires(searchResp.Items[0]), time.Minute, c.name)
+	}
+
+	if remoteUrl != nil {
+		// the rest of this test only works in-proc
+		return
+	}
+
+	// a blob that is not read expires
+	createResp = create(t, fmt.Sprintf("subject=%s&name=unused&_idleTtl=0s", subject), "text/plain", "content")
+	require.Equal(t, http.StatusCreated, createResp.StatusCode)
+	require.Nil(t, core.CollectGarbage(context.Background(), db, blobStore, time.Now().Add(time.Millisecond)))
+	assert.Equal(t, int64(1), countBlobs(t, "subject="+subject).Result.Count)
+
+	for _, invalid := range []string{"_idleTtl=1h&_ttl=1h", "_idleTtl=1h&_idleTtl=2h", "_idleTtl=-1h"} {
+		assert.Equal(t, http.StatusBadRequest, create(t, fmt.Sprintf("subject=%s&%s", subject, invalid), "text/plain", "").StatusCode, invalid)
+	}
+}
+
+func TestRetentionPolicies(t *testing.T) {
+	if remoteUrl != nil {
+		// this test only works in-proc
+		return
+	}
+
+	subject := fmt.Sprint(time.Now().UnixNano())
+
+	expiresIn := func(metadata map[string]interface{}) time.Duration {
+		require.Contains(t, metadata, "expires")
+		expires, err := time.Parse(time.RFC3339Nano, metadata["expires"].(string))
+		require.Nil(t, err)
+		return time.Until(expires)
+	}
+
+	createResp := create(t, fmt.Sprintf("subject=%s&retention=short", subject), "text/plain", "")
+	require.Equal(t, http.StatusCreated, createResp.StatusCode)
+	assert.InDelta(t, time.Hour, expiresIn(createResp.Meta), float64(time.Minute))
+
+	createResp = create(t, fmt.Sprintf("subject=%s&retention=longer", subject), "text/plain", "")
+	require.Equal(t, http.StatusCreated, createResp.StatusCode)
+	assert.InDelta(t, 720*time.Hour, expiresIn(createResp.Meta), float64(time.Minute))
+
+	// an expiration given by the client takes precedence
+	createResp = create(t, fmt.Sprintf("subject=%s&retention=short&_ttl=10m", subject), "text/plain", "")
+	require.Equal(t, http.StatusCreated, createResp.StatusCode)
+	assert.InDelta(t, 10*time.Minute, expiresIn(createResp.Meta), float64(time.Minute))
+
+	unmatched := create(t, fmt.Sprintf("subject=%s&retention=other", subject), "text/plain", "")
+	require.Equal(t, http.StatusCreated, unmatched.StatusCode)
+	assert.NotContains(t, unmatched.Meta, "expires")
+
+	stale := create(t, fmt.Sprintf("subject=%s&retention=stale", subject), "text/plain", "")
+	require.Equal(t, http.StatusCreated, stale.StatusCode)
+
+	// imported blobs without an expiration are given one by the policies, here by changing the tag in the manifest
+	exportResp, err := executeRequest("GET", fmt.Sprintf("/v1/blobs/archive?subject=%s&retention=other", subject), nil, nil)
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, exportResp.StatusCode)
+	exportReader := tar.NewReader(exportResp.Body)
+	importBuf := &bytes.Buffer{}
+	importWriter := tar.NewWriter(importBuf)
+	for {
+		header, err := exportReader.Next()
+		if err == io.EOF {
+			break
+		}
+		require.Nil(t, err)
+		contents, err := io.ReadAll(exportReader)
+		require.Nil(t, err)
+		if header.Name == api.ArchiveManifestName {
+			contents = bytes.ReplaceAll(contents, []byte(`"other"`), []byte(`"short"`))
+			header.Size = int64(len(contents))
+		}
+		require.Nil(t, importWriter.WriteHeader(header))
+		_, err = importWriter.Write(contents)
+		require.Nil(t, err)
+	}
+	require.Nil(t, importWriter.Close())
+
+	resp, err := executeRequest("POST", "/v1/blobs/archive", http.Header{"Content-Type": []string{"application/x-tar"}}, importBuf)
+	require.Nil(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	importResp := api.SearchResponse{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&importResp))
+	require.Len(t, importResp.Items, 1)
+	assert.Equal(t, "short", importResp.Items[0]["retention"])
+	assert.InDelta(t, time.Hour, expiresIn(importResp.Items[0]), float64(time.Minute))
+
+	// new policies apply retroactively to existing blobs without an expiration
+	policies, err := core.ParseRetentionPolicies(fmt.Sprintf("subject=%s&retention=other:2h;subject=%s&retention=st*:0s;subject=%s:1h", subject, subject, subject))
+	require.Nil(t, err)
+	require.Nil(t, core.ApplyRetentionPolicies(context.Background(), db, policies, time.Now()))
+
+	assert.InDelta(t, 2*time.Hour, expiresIn(get(t, unmatched.Location).Meta), float64(time.Minute))
+
+	resp, err = executeRequest("GET", stale.Location, nil, nil)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// blobs that already have an expiration are unchanged
+	assert.InDelta(t, 10*time.Minute, expiresIn(get(t, createResp.Location).Meta), float64(time.Minute))
+
+	require.Nil(t, core.CollectGarbage(context.Background(), db, blobStore, time.Now()))
+	assert.Equal(t, int64(5), countBlobs(t, "subject="+subject).Result.Count)
+}
+
+func TestLegalHold(t *testing.T) {
+	subject := fmt.Sprint(time.Now().UnixNano())
+	held := create(t, fmt.Sprintf("subject=%s&name=audited&_ttl=1h", subject), "text/plain", "evidence")
+	require.Equal(t, http.StatusCreated, held.StatusCode)
+	other := create(t, fmt.Sprintf("subject=%s&name=other", subject), "text/plain", "")
+	require.Equal(t, http.StatusCreated, other.StatusCode)
+
+	setHold := func(location string, body string) (*http.Response, map[string]interface{}) {
+		resp, err := executeRequest("PUT", location+"/hold", http.Header{"Content-Type": []string{"application/json"}}, strings.NewReader(body))
+		require.Nil(t, err)
+		metadata := make(map[string]interface{})
+		json.NewDecoder(resp.Body).Decode(&metadata)
+		return resp, metadata
+	}
+
+	for _, invalid := range []string{"", "{}", `{"reason": "  "}`, fmt.Sprintf(`{"reason": "%s"}`, strings.Repeat("a", 1025))} {
+		resp, _ := setHold(held.Location, invalid)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, invalid)
 	}
-}
 
-func TestExpiredBlobNotFound(t *testing.T) {
+	resp, _ := setHold(fmt.Sprintf("/v1/blobs/%s-%s", uuid.NewString(), subject), `{"reason": "audit"}`)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
 
-	body := "Getting an expired blob directly should return 404"
-	subject := fmt.Sprint(time.Now().UnixNano())
+	resp, metadata := setHold(held.Location, `{"reason": "Audit 2026-17"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, true, metadata["legalHold"])
+	assert.Equal(t, "Audit 2026-17", metadata["legalHoldReason"])
+	assert.Equal(t, `"2"`, resp.Header.Get("ETag"))
+	assert.Equal(t, "Audit 2026-17", get(t, held.Location).Meta["legalHoldReason"])
+	assert.NotContains(t, get(t, other.Location).Meta, "legalHold")
+
+	// the hold state cannot be changed like a tag
+	resp, err := executeRequest("PATCH", held.Location, http.Header{"Content-Type": []string{api.MergePatchContentType}}, strings.NewReader(`{"legalHold": null}`))
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, http.StatusBadRequest, create(t, fmt.Sprintf("subject=%s&legalHold=false", subject), "text/plain", "").StatusCode)
+
+	// search
+	heldOnly := search(t, fmt.Sprintf("subject=%s&_legalHold=true", subject)).Results.Items
+	require.Len(t, heldOnly, 1)
+	assert.Equal(t, held.Location, heldOnly[0]["location"])
+	notHeld := search(t, fmt.Sprintf("subject=%s&_legalHold=false", subject)).Results.Items
+	require.Len(t, notHeld, 1)
+	assert.Equal(t, other.Location, notHeld[0]["location"])
+	resp, err = executeRequest("GET", fmt.Sprintf("/v1/blobs?subject=%s&_legalHold=maybe", subject), nil, nil)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
 
-	response := create(
-		t,
-		fmt.Sprintf("subject=%s&session=expiration-lookup-test&_ttl=0s", subject),
-		"text/plain",
-		body)
+	// deletion
+	resp, err = executeRequest("DELETE", held.Location, nil, nil)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
 
-	require.Equal(t, http.StatusCreated, response.StatusCode)
-	require.Contains(t, response.Meta, "expires")
+	resp, err = executeRequest("DELETE", "/v1/admin/subjects?subject="+gourl.QueryEscape(subject), nil, nil)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
 
-	time.Sleep(50 * time.Millisecond)
+	for _, dryRun := range []bool{true, false} {
+		resp, err = executeRequest("DELETE", fmt.Sprintf("/v1/blobs?subject=%s&_dryRun=%t", subject, dryRun), nil, nil)
+		require.Nil(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		deleteResp := api.DeleteResponse{}
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&deleteResp))
+		assert.Equal(t, 1, deleteResp.Count)
+	}
 
-	response = get(t, response.Location)
+	assert.Equal(t, "evidence", read(t, held.Data).Body)
 
-	require.Equal(t, http.StatusNotFound, response.StatusCode)
-}
+	// expiration
+	resp, err = executeRequest("PATCH", held.Location, http.Header{"Content-Type": []string{api.MergePatchContentType}}, strings.NewReader(`{"_ttl": "0s"}`))
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
 
-func TestBlobCreatedWithTimeToLiveHasExpiration(t *testing.T) {
+	if remoteUrl == nil {
+		require.Nil(t, core.CollectGarbage(context.Background(), db, blobStore, time.Now().Add(time.Second)))
+	}
 
-	body := "This is a body. It will do nicely."
-	subject := fmt.Sprint(time.Now().UnixNano())
-	ttl := "45m"
+	assert.Equal(t, "evidence", read(t, held.Data).Body)
 
-	createResponse := create(
-		t,
-		fmt.Sprintf("subject=%s&session=mysession&_ttl=%s", subject, ttl),
-		"text/plain",
-		body)
-	require.Equal(t, http.StatusCreated, createResponse.StatusCode)
+	resp, err = executeRequest("DELETE", held.Location+"/hold", nil, nil)
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	metadata = make(map[string]interface{})
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&metadata))
+	assert.NotContains(t, metadata, "legalHold")
 
-	readResponse := get(t, createResponse.Location)
-	require.Equal(t, http.StatusOK, readResponse.StatusCode)
-	require.NotNil(t, readResponse.Meta["expires"])
-	require.Equal(t, createResponse.Meta["expires"], readResponse.Meta["expires"])
+	// the blob expired while it was under legal hold
+	resp, err = executeRequest("GET", held.Location, nil, nil)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
 }
 
-func TestBlobCreateWithTimeToLiveHasExpirationDataHeader(t *testing.T) {
-	body := "This is a body. Just bytes really."
+func TestRangeRequests(t *testing.T) {
 	subject := fmt.Sprint(time.Now().UnixNano())
-	ttl := "5m"
+	query := fmt.Sprintf("subject=%s&name=ranges", subject)
 
-	createResponse := create(
-		t,
-		fmt.Sprintf("subject=%s&session=mysession&_ttl=%s", subject, ttl),
-		"text/plain",
-		body)
-	require.Equal(t, http.StatusCreated, createResponse.StatusCode)
+	createResp := create(t, query, "text/plain", "0123456789")
+	require.Equal(t, http.StatusCreated, createResp.StatusCode)
 
-	data := read(t, createResponse.Data)
-	require.NotNil(t, data.ExpiresAt)
+	for _, url := range []string{createResp.Data, "/v1/blobs/data/latest?" + query} {
+		t.Run(url, func(t *testing.T) {
+			resp, err := executeRequest("GET", url, http.Header{"Range": []string{"bytes=2-5"}}, nil)
+			require.Nil(t, err)
+			readResp := populateBlobResponse(t, resp)
+			assert.Equal(t, http.StatusPartialContent, readResp.StatusCode)
+			assert.Equal(t, "2345", readResp.Body)
+			assert.Equal(t, "bytes 2-5/10", resp.Header.Get("Content-Range"))
+			assert.Equal(t, "text/plain", *readResp.Tags.ContentType)
+			assert.Equal(t, "ranges", *readResp.Tags.Name)
+
+			resp, err = executeRequest("GET", url, http.Header{"Range": []string{"bytes=-3"}}, nil)
+			require.Nil(t, err)
+			readResp = populateBlobResponse(t, resp)
+			assert.Equal(t, http.StatusPartialContent, readResp.StatusCode)
+			assert.Equal(t, "789", readResp.Body)
+
+			resp, err = executeRequest("GET", url, http.Header{"Range": []string{"bytes=20-"}}, nil)
+			require.Nil(t, err)
+			assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, resp.StatusCode)
+
+			// an If-Range value that does not match results in the full content
+			staleHeaders := http.Header{
+				"Range":    []string{"bytes=2-5"},
+				"If-Range": []string{time.Now().Add(-24 * time.Hour).UTC().Format(http.TimeFormat)},
+			}
+			resp, err = executeRequest("GET", url, staleHeaders, nil)
+			require.Nil(t, err)
+			readResp = populateBlobResponse(t, resp)
+			assert.Equal(t, http.StatusOK, readResp.StatusCode)
+			assert.Equal(t, "0123456789", readResp.Body)
+
+			resp, err = executeRequest("HEAD", url, nil, nil)
+			require.Nil(t, err)
+			readResp = populateBlobResponse(t, resp)
+			assert.Equal(t, http.StatusOK, readResp.StatusCode)
+			assert.Empty(t, readResp.Body)
+			assert.Equal(t, "10", resp.Header.Get("Content-Length"))
+			assert.Equal(t, "bytes", resp.Header.Get("Accept-Ranges"))
+			assert.Equal(t, subject, readResp.Subject)
+		})
+	}
+
+	resp, err := executeRequest("HEAD", fmt.Sprintf("/v1/blobs/data/latest?subject=%s-missing", subject), nil, nil)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
 }
 
-func TestCreateResponse(t *testing.T) {
+func TestConditionalRequests(t *testing.T) {
+	subject := fmt.Sprint(time.Now().UnixNano())
+	query := fmt.Sprintf("subject=%s&name=conditional", subject)
 
-	body := "these are some bytes"
-	subject := "$null"
+	createResp := create(t, query, "text/plain", "cache me")
+	require.Equal(t, http.StatusCreated, createResp.StatusCode)
 
-	response := create(t, fmt.Sprintf("subject=%s&session=mysession", subject), "text/plain", body)
-	require.Equal(t, http.StatusCreated, response.StatusCode)
+	resp, err := executeRequest("GET", createResp.Data, nil, nil)
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	etag := resp.Header.Get("ETag")
+	lastModified := resp.Header.Get("Last-Modified")
+	require.NotEmpty(t, etag)
+	require.NotEmpty(t, lastModified)
+	assert.Equal(t, "private, no-cache", resp.Header.Get("Cache-Control"))
+
+	for _, url := range []string{createResp.Data, "/v1/blobs/data/latest?" + query} {
+		t.Run(url, func(t *testing.T) {
+			for _, method := range []string{"GET", "HEAD"} {
+				resp, err := executeRequest(method, url, http.Header{"If-None-Match": []string{etag}}, nil)
+				require.Nil(t, err)
+				readResp := populateBlobResponse(t, resp)
+				assert.Equal(t, http.StatusNotModified, readResp.StatusCode)
+				assert.Empty(t, readResp.Body)
+				assert.Equal(t, etag, resp.Header.Get("ETag"))
+				require.NotNil(t, readResp.CreatedAt)
+				assert.Equal(t, lastModified, readResp.CreatedAt.Format(http.TimeFormat))
+			}
 
-	assert.NotNil(t, response.Meta["lastModified"])
-	assert.Equal(t, "text/plain", response.Meta["contentType"])
+			resp, err := executeRequest("GET", url, http.Header{"If-None-Match": []string{`"other", W/` + etag}}, nil)
+			require.Nil(t, err)
+			assert.Equal(t, http.StatusNotModified, resp.StatusCode)
 
-	assert.Equal(t, "mysession", response.Meta["session"])
-	assert.Equal(t, "$null", response.Meta["subject"])
-	assert.Nil(t, response.Meta["name"])
+			resp, err = executeRequest("GET", url, http.Header{"If-None-Match": []string{`"other"`}}, nil)
+			require.Nil(t, err)
+			readResp := populateBlobResponse(t, resp)
+			assert.Equal(t, http.StatusOK, readResp.StatusCode)
+			assert.Equal(t, "cache me", readResp.Body)
 
-	assert.NotNil(t, response.Meta["location"])
-	assert.NotNil(t, response.Meta["data"])
-}
+			resp, err = executeRequest("GET", url, http.Header{"If-Modified-Since": []string{lastModified}}, nil)
+			require.Nil(t, err)
+			assert.Equal(t, http.StatusNotModified, resp.StatusCode)
 
-func TestCreateResponseCustomTags(t *testing.T) {
+			earlier := time.Now().Add(-24 * time.Hour).UTC().Format(http.TimeFormat)
+			resp, err = executeRequest("GET", url, http.Header{"If-Modified-Since": []string{earlier}}, nil)
+			require.Nil(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
 
-	body := "these are some bytes"
-	subject := fmt.Sprint(time.Now().UnixNano())
+			// If-Modified-Since is ignored when If-None-Match is present
+			headers := http.Header{"If-None-Match": []string{`"other"`}, "If-Modified-Since": []string{lastModified}}
+			resp, err = executeRequest("GET", url, headers, nil)
+			require.Nil(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		})
+	}
 
-	// Create the blob
-	response := create(
-		t,
-		fmt.Sprintf("subject=%s&session=mysession&customtag1=customTag1Value&customTag2=customTag2Value1&customTag2=customTag2Value2", subject),
-		"text/plain",
-		body)
-	require.Equal(t, http.StatusCreated, response.StatusCode)
+	// tags and the expiration can change, so revalidation returns their current values
+	resp, err = executeRequest("PATCH", createResp.Location, http.Header{"Content-Type": []string{api.MergePatchContentType}}, strings.NewReader(`{"reviewed": "yes", "_ttl": "1h"}`))
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
 
-	require.Equal(t, "customTag1Value", response.Meta["customtag1"])
-	require.ElementsMatch(t, []string{"customTag2Value1", "customTag2Value2"}, response.Meta["customtag2"])
+	resp, err = executeRequest("GET", createResp.Data, http.Header{"If-None-Match": []string{etag}}, nil)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	assert.Equal(t, "yes", resp.Header.Get(api.TagHeaderName("reviewed")))
+	assert.NotEmpty(t, resp.Header.Get("Expires"))
 }
 
-func TestCreateResponseMatchesBlobMeta(t *testing.T) {
+func TestDeleteBlob(t *testing.T) {
+	subject := fmt.Sprint(time.Now().UnixNano())
 
-	body := "these are some bytes"
-	subject := "$null"
+	createResp := create(t, fmt.Sprintf("subject=%s&name=delete-me&mytag=t", subject), "text/plain", "delete me")
+	require.Equal(t, http.StatusCreated, createResp.StatusCode)
 
-	createResponse := create(t, fmt.Sprintf("subject=%s&session=mysession", subject), "text/plain", body)
-	require.Equal(t, http.StatusCreated, createResponse.StatusCode)
+	resp := deleteBlob(t, createResp.Location)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
 
-	readResponse := get(t, createResponse.Location)
+	assert.Equal(t, http.StatusNotFound, get(t, createResp.Location).StatusCode)
+	assert.Equal(t, http.StatusNotFound, read(t, createResp.Data).StatusCode)
+	assert.Empty(t, search(t, "subject="+subject).Results.Items)
 
-	require.Equal(t, http.StatusOK, readResponse.StatusCode)
-	require.True(t, reflect.DeepEqual(createResponse.Meta, readResponse.Meta))
-}
+	resp = deleteBlob(t, createResp.Location)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
 
-func TestSearchPaging(t *testing.T) {
+	resp, err := executeRequest("DELETE", "/v1/blobs/abc", nil, nil)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
 
+func TestDeleteMatchingBlobs(t *testing.T) {
 	subject := fmt.Sprint(time.Now().UnixNano())
 
-	// create several blobs with the same subject
-	totalItems := 10
-	originalQuery := fmt.Sprintf("subject=%s&mytag=t", subject)
-	for i := 0; i < totalItems; i++ {
-		require.Equal(t, http.StatusCreated, create(t, originalQuery, "", "").StatusCode)
+	for i := 0; i < 3; i++ {
+		require.Equal(t, http.StatusCreated, create(t, fmt.Sprintf("subject=%s&session=failed", subject), "", "").StatusCode)
 	}
+	require.Equal(t, http.StatusCreated, create(t, fmt.Sprintf("subject=%s&session=good", subject), "", "").StatusCode)
 
-	for _, pageSize := range []int{3, 5, 8, 10, 11} {
+	resp := deleteMatching(t, fmt.Sprintf("subject=%s&session=failed&_dryRun", subject))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 3, resp.Result.Count)
+	assert.True(t, resp.Result.DryRun)
+	assert.Len(t, search(t, fmt.Sprintf("subject=%s&session=failed", subject)).Results.Items, 3)
 
-		t.Run(fmt.Sprintf("page size %d", pageSize), func(t *testing.T) {
+	resp = deleteMatching(t, fmt.Sprintf("subject=%s&session=failed", subject))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 3, resp.Result.Count)
+	assert.False(t, resp.Result.DryRun)
+	assert.Zero(t, resp.Result.Remaining)
+	assert.Empty(t, search(t, fmt.Sprintf("subject=%s&session=failed", subject)).Results.Items)
+	assert.Len(t, search(t, fmt.Sprintf("subject=%s", subject)).Results.Items, 1)
 
-			link := fmt.Sprintf("/v1/blobs?subject=%s&mytag=t&_limit=%d", subject, pageSize)
+	resp = deleteMatching(t, fmt.Sprintf("subject=%s&session=failed", subject))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 0, resp.Result.Count)
 
-			items := make(map[string]bool)
-			for link != "" {
+	assert.Equal(t, http.StatusBadRequest, deleteMatching(t, "session=failed").StatusCode)
+	assert.Equal(t, http.StatusBadRequest, deleteMatching(t, fmt.Sprintf("subject=%s&_dryRun=maybe", subject)).StatusCode)
+}
 
-				resp := search(t, link[strings.Index(link, "?")+1:])
+func TestPurgeSubject(t *testing.T) {
+	subject := fmt.Sprint(time.Now().UnixNano())
+	otherSubject := subject + "-other"
 
-				assert.Equal(t, http.StatusOK, resp.StatusCode)
-				assert.LessOrEqual(t, len(resp.Results.Items), pageSize)
+	createResp := create(t, fmt.Sprintf("subject=%s&session=s1&mytag=a&mytag=b", subject), "", "first")
+	require.Equal(t, http.StatusCreated, createResp.StatusCode)
+	require.Equal(t, http.StatusCreated, create(t, fmt.Sprintf("subject=%s&session=s2", subject), "", "second").StatusCode)
+	require.Equal(t, http.StatusCreated, create(t, fmt.Sprintf("subject=%s", otherSubject), "", "other").StatusCode)
 
-				for _, v := range resp.Results.Items {
-					location := v["location"].(string)
-					assert.NotContains(t, items, location)
-					items[string(location)] = true
-				}
+	var stagedKey, orphanedKey core.BlobKey
+	if remoteUrl == nil {
+		// a blob that is in the process of being written should also be erased
+		stagedKey = createKey(t, subject)
+		_, err := db.StageBlobMetadata(context.Background(), stagedKey, &core.BlobTags{})
+		require.Nil(t, err)
+		require.Nil(t, blobStore.SaveBlob(context.Background(), strings.NewReader("staged"), stagedKey))
 
-				link = resp.Results.NextLink
-			}
+		// as should content that no metadata refers to
+		orphanedKey = createKey(t, subject)
+		require.Nil(t, blobStore.SaveBlob(context.Background(), strings.NewReader("orphaned"), orphanedKey))
+	}
 
-			assert.Equal(t, len(items), totalItems)
-		})
+	resp := purgeSubject(t, subject)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, subject, resp.Result.Subject)
+	assert.NotEmpty(t, resp.Result.CompletedAt)
+	if remoteUrl == nil {
+		assert.Len(t, resp.Result.ErasedBlobIds, 3)
+		assert.Contains(t, resp.Result.ErasedBlobIds, stagedKey.Id.String())
+		assert.ErrorIs(t, blobStore.ReadBlob(context.Background(), io.Discard, stagedKey), core.ErrBlobNotFound)
+		assert.ErrorIs(t, blobStore.ReadBlob(context.Background(), io.Discard, orphanedKey), core.ErrBlobNotFound)
+		assert.ErrorIs(t, db.CompleteStagedBlobMetadata(context.Background(), stagedKey, 0, nil, nil), core.ErrStagedRecordNotFound)
+	} else {
+		assert.Len(t, resp.Result.ErasedBlobIds, 2)
 	}
 
-	// now verify the behavior of the _at parameter for searches and get latest calls
-	fullResults := search(t, originalQuery+"&_limit=0") // <= 0 should be ignored
-	assert.Empty(t, fullResults.Results.NextLink)
+	assert.Empty(t, search(t, "subject="+subject).Results.Items)
+	assert.Equal(t, http.StatusNotFound, read(t, createResp.Data).StatusCode)
+	assert.Len(t, search(t, "subject="+otherSubject).Results.Items, 1)
 
-	for i := 1; i < len(fullResults.Results.Items); i++ {
-		previousResult := fullResults.Results.Items[i-1]
-		thisResult := fullResults.Results.Items[i]
-		if prevTime, thisTime := previousResult["lastModified"].(string), thisResult["lastModified"].(string); prevTime != thisTime {
-			assert.Regexp(t, "Z$", thisTime, "Datetime in response not in UTC")
-			atQuery := fmt.Sprintf("%s&_at=%s", originalQuery, gourl.QueryEscape(thisTime))
-			atRes := search(t, atQuery)
+	resp = purgeSubject(t, subject)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Result.ErasedBlobIds)
 
-			require.Equal(t, http.StatusOK, atRes.StatusCode)
-			assert.Equal(t, thisResult["location"].(string), atRes.Results.Items[0]["location"].(string))
+	assert.Equal(t, http.StatusBadRequest, purgeSubject(t, "$null").StatusCode)
+	assert.Equal(t, http.StatusBadRequest, purgeSubject(t, "").StatusCode)
+}
 
-			latestResponse := getLatestBlob(t, atQuery)
-			require.Equal(t, http.StatusOK, latestResponse.StatusCode)
-			assert.Equal(t, thisResult["location"].(string), latestResponse.Location)
-		}
+func TestFailedSaveLeavesNoContent(t *testing.T) {
+	if remoteUrl != nil {
+		// this test only works in-proc
+		return
 	}
+
+	key := createKey(t, fmt.Sprint(time.Now().UnixNano()))
+	failingReader := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(errors.New("connection reset")))
+	require.NotNil(t, blobStore.SaveBlob(context.Background(), failingReader, key))
+	assert.ErrorIs(t, blobStore.ReadBlob(context.Background(), io.Discard, key), core.ErrBlobNotFound)
 }
 
 func TestInvalidSearches(t *testing.T) {
@@ -417,6 +1933,13 @@ func TestInvalidSearches(t *testing.T) {
 		"subject=x&_at=foobar",
 		"subject=x&_at=2021",
 		"subject=x&_at=2021-10-18T16:56:15.693Z&_at=2021-10-18T16:56:15.693Z",
+		"subject=x&name:like=a",
+		"subject:ne=x",
+		"subject:absent",
+		"subject=x&_sort=color",
+		"subject=x&_sort=name&_sort=size",
+		"subject=x&_createdAfter=2021",
+		"subject=x&_createdBefore=2021-10-18T16:56:15.693Z&_createdBefore=2021-10-18T16:56:15.693Z",
 	}
 
 	for _, c := range cases {
@@ -546,6 +2069,126 @@ func get(t *testing.T, location string) MetaResponse {
 	return createMetaResponse(resp)
 }
 
+func deleteBlob(t *testing.T, location string) *http.Response {
+
+	url, err := gourl.Parse(location)
+	require.Nil(t, err)
+
+	resp, err := executeRequest("DELETE", url.Path, nil, nil)
+	require.Nil(t, err)
+
+	return resp
+}
+
+func deleteMatching(t *testing.T, queryString string) DeleteResponse {
+	resp, err := executeRequest("DELETE", fmt.Sprintf("/v1/blobs?%s", queryString), nil, nil)
+	require.Nil(t, err)
+
+	deleteResponse := DeleteResponse{}
+	deleteResponse.RawResponse = resp
+	deleteResponse.StatusCode = resp.StatusCode
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusOK {
+		result := api.DeleteResponse{}
+		require.Nil(t, json.Unmarshal(body, &result))
+		deleteResponse.Result = &result
+	} else {
+		errorResponse := api.ErrorResponse{}
+		if json.Unmarshal(body, &errorResponse) == nil {
+			deleteResponse.ErrorResponse = &errorResponse
+		}
+	}
+
+	return deleteResponse
+}
+
+func countBlobs(t *testing.T, queryString string) CountResponse {
+	resp, err := executeRequest("GET", fmt.Sprintf("/v1/blobs/count?%s", queryString), nil, nil)
+	require.Nil(t, err)
+
+	countResponse := CountResponse{}
+	countResponse.RawResponse = resp
+	countResponse.StatusCode = resp.StatusCode
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusOK {
+		result := api.CountResponse{}
+		require.Nil(t, json.Unmarshal(body, &result))
+		countResponse.Result = &result
+	} else {
+		errorResponse := api.ErrorResponse{}
+		if json.Unmarshal(body, &errorResponse) == nil {
+			countResponse.ErrorResponse = &errorResponse
+		}
+	}
+
+	return countResponse
+}
+
+func blobFacets(t *testing.T, queryString string) FacetsResponse {
+	resp, err := executeRequest("GET", fmt.Sprintf("/v1/blobs/facets?%s", queryString), nil, nil)
+	require.Nil(t, err)
+
+	facetsResponse := FacetsResponse{}
+	facetsResponse.RawResponse = resp
+	facetsResponse.StatusCode = resp.StatusCode
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusOK {
+		result := api.FacetsResponse{}
+		require.Nil(t, json.Unmarshal(body, &result))
+		facetsResponse.Result = &result
+	} else {
+		errorResponse := api.ErrorResponse{}
+		if json.Unmarshal(body, &errorResponse) == nil {
+			facetsResponse.ErrorResponse = &errorResponse
+		}
+	}
+
+	return facetsResponse
+}
+
+// Follows the nextLink of a list endpoint until all values have been read
+func listAll(t *testing.T, link string) []string {
+	values := []string{}
+	for link != "" {
+		resp, err := executeRequest("GET", link, nil, nil)
+		require.Nil(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		listResponse := api.ListResponse{}
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&listResponse))
+		values = append(values, listResponse.Items...)
+		link = listResponse.NextLink
+	}
+
+	return values
+}
+
+func purgeSubject(t *testing.T, subject string) PurgeSubjectResponse {
+	resp, err := executeRequest("DELETE", "/v1/admin/subjects?subject="+gourl.QueryEscape(subject), nil, nil)
+	require.Nil(t, err)
+
+	purgeResponse := PurgeSubjectResponse{}
+	purgeResponse.RawResponse = resp
+	purgeResponse.StatusCode = resp.StatusCode
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusOK {
+		result := api.PurgeSubjectResponse{}
+		require.Nil(t, json.Unmarshal(body, &result))
+		purgeResponse.Result = &result
+	} else {
+		errorResponse := api.ErrorResponse{}
+		if json.Unmarshal(body, &errorResponse) == nil {
+			purgeResponse.ErrorResponse = &errorResponse
+		}
+	}
+
+	return purgeResponse
+}
+
 func createMetaResponse(resp *http.Response) MetaResponse {
 	response := MetaResponse{}
 	response.RawResponse = resp
@@ -771,7 +2414,7 @@ func TestStagedBlobsAreNotVisible(t *testing.T) {
 	latestResponse := getLatestBlob(t, query)
 	assert.Equal(t, http.StatusNotFound, latestResponse.StatusCode)
 
-	err = db.CompleteStagedBlobMetadata(context.Background(), key)
+	err = db.CompleteStagedBlobMetadata(context.Background(), key, 0, nil, nil)
 	require.Nil(t, err)
 
 	searchResponse = search(t, query)
@@ -780,6 +2423,14 @@ func TestStagedBlobsAreNotVisible(t *testing.T) {
 	assert.Equal(t, http.StatusOK, latestResponse.StatusCode)
 }
 
+// Parses the key from a blob's location URI, which ends with the blob ID followed by the subject
+func keyFromLocation(t *testing.T, location string) core.BlobKey {
+	combinedId := path.Base(location)
+	id, err := uuid.Parse(combinedId[:36])
+	require.Nil(t, err)
+	return core.BlobKey{Id: id, Subject: combinedId[37:]}
+}
+
 func createKey(t *testing.T, subject string) core.BlobKey {
 	id := uuid.New()
 	return core.BlobKey{Subject: subject, Id: id}
@@ -796,6 +2447,33 @@ type SearchResponse struct {
 	Results *api.SearchResponse
 }
 
+type BatchResponse struct {
+	Results []struct {
+		Status int
+		Item   map[string]interface{}
+	}
+}
+
+type DeleteResponse struct {
+	Response
+	Result *api.DeleteResponse
+}
+
+type CountResponse struct {
+	Response
+	Result *api.CountResponse
+}
+
+type FacetsResponse struct {
+	Response
+	Result *api.FacetsResponse
+}
+
+type PurgeSubjectResponse struct {
+	Response
+	Result *api.PurgeSubjectResponse
+}
+
 type MetaResponse struct {
 	Response
 	Location string
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -28,38 +29,74 @@ const (
 )
 
 type Args struct {
-	PrettyPrint      bool   `help:"Pretty-print logs." short:"p"`
-	LogLevel         string `help:"Set the minimum log level to emit." short:"l" default:"Info" enum:"Debug,Info,Warn,Error,Fatal,Panic,Disabled"`
-	RequireParentPid int    `help:"Exit when the parent process' PID differs from the given value." default:"-1" hidden:""`
+	PrettyPrint bool   `help:"Pretty-print logs." short:"p"`
+	LogLevel    string `help:"Set the minimum log level to emit." short:"l" default:"Info" enum:"Debug,Info,Warn,Error,Fatal,Panic,Disabled"`
+
+	Serve        ServeCmd        `cmd:"" default:"withargs" help:"Run the server. This is the default command."`
+	PurgeSubject PurgeSubjectCmd `cmd:"" help:"Permanently delete all blobs and metadata for a subject."`
+}
+
+type ServeCmd struct {
+	RequireParentPid int `help:"Exit when the parent process' PID differs from the given value." default:"-1" hidden:""`
+}
+
+type PurgeSubjectCmd struct {
+	Subject string `arg:"" help:"The subject whose data should be erased."`
 }
 
 func main() {
 	args := Args{}
-	kong.Parse(&args, kong.UsageOnError())
+	ctx := kong.Parse(&args, kong.UsageOnError())
 
 	configureZerolog(args)
 
-	startParentProcessCheck(args)
+	if err := ctx.Run(); err != nil {
+		log.Fatal().Err(err).Send()
+	}
+}
+
+func (cmd *ServeCmd) Run() error {
+	startParentProcessCheck(cmd.RequireParentPid)
 
 	config := loadConfig()
 
 	db, blobStore, err := assembleDataStores(config)
 	if err != nil {
-		log.Fatal().Err(err).Send()
+		return err
+	}
+
+	retentionPolicies, err := core.ParseRetentionPolicies(config.RetentionPolicies)
+	if err != nil {
+		return err
 	}
 
-	handler := assembleHandler(db, blobStore, config)
+	handler := assembleHandler(db, blobStore, retentionPolicies, config)
 
-	go garbageCollectionLoop(context.Background(), db, blobStore)
+	go garbageCollectionLoop(context.Background(), db, blobStore, retentionPolicies)
 
 	l, err := net.Listen("tcp", fmt.Sprintf(":%d", config.Port))
 	if err != nil {
-		log.Fatal().Err(err).Send()
+		return err
 	}
 
 	log.Info().Msgf("Listening on port %d", config.Port)
-	err = http.Serve(l, handler)
-	log.Fatal().Err(err).Send()
+	return http.Serve(l, handler)
+}
+
+func (cmd *PurgeSubjectCmd) Run() error {
+	if cmd.Subject == api.NullSubject {
+		return errors.New("blobs that are not associated with a subject cannot be purged")
+	}
+
+	config := loadConfig()
+
+	db, blobStore, err := assembleDataStores(config)
+	if err != nil {
+		return err
+	}
+
+	_, err = core.PurgeSubject(context.Background(), db, blobStore, cmd.Subject)
+	return err
 }
 
 func loadConfig() ConfigSpec {
@@ -86,8 +123,8 @@ func assembleDataStores(config ConfigSpec) (core.MetadataDatabase, core.BlobStor
 	return db, blobStore, nil
 }
 
-func assembleHandler(db core.MetadataDatabase, blobStore core.BlobStore, config ConfigSpec) http.Handler {
-	return api.BuildRouter(db, blobStore, config.LogRequests)
+func assembleHandler(db core.MetadataDatabase, blobStore core.BlobStore, retentionPolicies []core.RetentionPolicy, config ConfigSpec) http.Handler {
+	return api.BuildRouter(db, blobStore, retentionPolicies, config.LogRequests)
 }
 
 func createMetadataRepository(config ConfigSpec) (core.MetadataDatabase, error) {
@@ -111,9 +148,13 @@ func createBlobStore(config ConfigSpec) (core.BlobStore, error) {
 	return nil, fmt.Errorf("unrecognized storage provider '%s'", config.StorageProvider)
 }
 
-func garbageCollectionLoop(ctx context.Context, db core.MetadataDatabase, blobStore core.BlobStore) {
+func garbageCollectionLoop(ctx context.Context, db core.MetadataDatabase, blobStore core.BlobStore, retentionPolicies []core.RetentionPolicy) {
+	applyRetentionPolicies(ctx, db, retentionPolicies)
+
 	ticker := time.NewTicker(30 * time.Minute)
 	for range ticker.C {
+		applyRetentionPolicies(ctx, db, retentionPolicies)
+
 		for i := 0; i < 10; i++ {
 			log.Ctx(ctx).Info().Msg("Begining garbage collection")
 			err := core.CollectGarbage(ctx, db, blobStore, time.Now().Add(-30*time.Minute).UTC())
@@ -128,6 +169,18 @@ func garbageCollectionLoop(ctx context.Context, db core.MetadataDatabase, blobSt
 	}
 }
 
+// Applies the retention policies to existing blobs, so that policies added since they were
+// created also take effect. Failures are logged and retried on the next iteration.
+func applyRetentionPolicies(ctx context.Context, db core.MetadataDatabase, retentionPolicies []core.RetentionPolicy) {
+	if len(retentionPolicies) == 0 {
+		return
+	}
+
+	if err := core.ApplyRetentionPolicies(ctx, db, retentionPolicies, time.Now().UTC()); err != nil {
+		log.Ctx(ctx).Error().Msgf("Applying retention policies failed: %v", err)
+	}
+}
+
 func configureZerolog(args Args) {
 
 	zerolog.TimeFieldFormat = "2006-01-02T15:04:05.999Z07:00"
@@ -160,8 +213,8 @@ func configureZerolog(args Args) {
 	zerolog.DefaultContextLogger = &log.Logger
 }
 
-func startParentProcessCheck(args Args) {
-	if args.RequireParentPid < 0 {
+func startParentProcessCheck(requireParentPid int) {
+	if requireParentPid < 0 {
 		return
 	}
 
@@ -170,7 +223,7 @@ func startParentProcessCheck(args Args) {
 	// the call to os.Getppdid() will start returning 1.
 	go func() {
 		for {
-			if os.Getppid() != args.RequireParentPid {
+			if os.Getppid() != requireParentPid {
 				log.Fatal().Msg("Terminating because the parent process has exited")
 			}
 			time.Sleep(time.Second)
@@ -186,4 +239,5 @@ type ConfigSpec struct {
 	StorageConnectionString  string `default:"_data/blobs"`
 	Port                     int    `default:"3333"`
 	LogRequests              bool   `default:"true"`
+	RetentionPolicies        string
 }
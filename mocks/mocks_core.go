@@ -37,18 +37,48 @@ func (m *MockMetadataDatabase) EXPECT() *MockMetadataDatabaseMockRecorder {
 	return m.recorder
 }
 
+// ApplyBlobTimeToLive mocks base method.
+func (m *MockMetadataDatabase) ApplyBlobTimeToLive(arg0 context.Context, arg1 *core.SearchFilter, arg2 time.Duration, arg3 time.Time) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ApplyBlobTimeToLive", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ApplyBlobTimeToLive indicates an expected call of ApplyBlobTimeToLive.
+func (mr *MockMetadataDatabaseMockRecorder) ApplyBlobTimeToLive(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ApplyBlobTimeToLive", reflect.TypeOf((*MockMetadataDatabase)(nil).ApplyBlobTimeToLive), arg0, arg1, arg2, arg3)
+}
+
 // CompleteStagedBlobMetadata mocks base method.
-func (m *MockMetadataDatabase) CompleteStagedBlobMetadata(arg0 context.Context, arg1 core.BlobKey) error {
+func (m *MockMetadataDatabase) CompleteStagedBlobMetadata(arg0 context.Context, arg1 core.BlobKey, arg2 int64, arg3 []byte, arg4 *time.Time) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CompleteStagedBlobMetadata", arg0, arg1)
+	ret := m.ctrl.Call(m, "CompleteStagedBlobMetadata", arg0, arg1, arg2, arg3, arg4)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // CompleteStagedBlobMetadata indicates an expected call of CompleteStagedBlobMetadata.
-func (mr *MockMetadataDatabaseMockRecorder) CompleteStagedBlobMetadata(arg0, arg1 interface{}) *gomock.Call {
+func (mr *MockMetadataDatabaseMockRecorder) CompleteStagedBlobMetadata(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CompleteStagedBlobMetadata", reflect.TypeOf((*MockMetadataDatabase)(nil).CompleteStagedBlobMetadata), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CompleteStagedBlobMetadata", reflect.TypeOf((*MockMetadataDatabase)(nil).CompleteStagedBlobMetadata), arg0, arg1, arg2, arg3, arg4)
+}
+
+// CountBlobMetadata mocks base method.
+func (m *MockMetadataDatabase) CountBlobMetadata(arg0 context.Context, arg1 *core.SearchFilter, arg2 time.Time) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountBlobMetadata", arg0, arg1, arg2)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CountBlobMetadata indicates an expected call of CountBlobMetadata.
+func (mr *MockMetadataDatabaseMockRecorder) CountBlobMetadata(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountBlobMetadata", reflect.TypeOf((*MockMetadataDatabase)(nil).CountBlobMetadata), arg0, arg1, arg2)
 }
 
 // DeleteBlobMetadata mocks base method.
@@ -65,6 +95,20 @@ func (mr *MockMetadataDatabaseMockRecorder) DeleteBlobMetadata(arg0, arg1 interf
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteBlobMetadata", reflect.TypeOf((*MockMetadataDatabase)(nil).DeleteBlobMetadata), arg0, arg1)
 }
 
+// ExtendBlobExpiration mocks base method.
+func (m *MockMetadataDatabase) ExtendBlobExpiration(arg0 context.Context, arg1 core.BlobKey, arg2 time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExtendBlobExpiration", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ExtendBlobExpiration indicates an expected call of ExtendBlobExpiration.
+func (mr *MockMetadataDatabaseMockRecorder) ExtendBlobExpiration(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExtendBlobExpiration", reflect.TypeOf((*MockMetadataDatabase)(nil).ExtendBlobExpiration), arg0, arg1, arg2)
+}
+
 // GetBlobMetadata mocks base method.
 func (m *MockMetadataDatabase) GetBlobMetadata(arg0 context.Context, arg1 core.BlobKey, arg2 time.Time) (*core.BlobInfo, error) {
 	m.ctrl.T.Helper()
@@ -80,6 +124,37 @@ func (mr *MockMetadataDatabaseMockRecorder) GetBlobMetadata(arg0, arg1, arg2 int
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBlobMetadata", reflect.TypeOf((*MockMetadataDatabase)(nil).GetBlobMetadata), arg0, arg1, arg2)
 }
 
+// GetBlobMetadataFacets mocks base method.
+func (m *MockMetadataDatabase) GetBlobMetadataFacets(arg0 context.Context, arg1 *core.SearchFilter, arg2 []string, arg3 time.Time) (map[string][]core.FacetValue, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetBlobMetadataFacets", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(map[string][]core.FacetValue)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetBlobMetadataFacets indicates an expected call of GetBlobMetadataFacets.
+func (mr *MockMetadataDatabaseMockRecorder) GetBlobMetadataFacets(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBlobMetadataFacets", reflect.TypeOf((*MockMetadataDatabase)(nil).GetBlobMetadataFacets), arg0, arg1, arg2, arg3)
+}
+
+// GetPageOfDistinctValues mocks base method.
+func (m *MockMetadataDatabase) GetPageOfDistinctValues(arg0 context.Context, arg1 core.DistinctValueKind, arg2 *string, arg3 *core.ContinutationToken, arg4 int, arg5 time.Time) ([]string, *core.ContinutationToken, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPageOfDistinctValues", arg0, arg1, arg2, arg3, arg4, arg5)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(*core.ContinutationToken)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetPageOfDistinctValues indicates an expected call of GetPageOfDistinctValues.
+func (mr *MockMetadataDatabaseMockRecorder) GetPageOfDistinctValues(arg0, arg1, arg2, arg3, arg4, arg5 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPageOfDistinctValues", reflect.TypeOf((*MockMetadataDatabase)(nil).GetPageOfDistinctValues), arg0, arg1, arg2, arg3, arg4, arg5)
+}
+
 // GetPageOfExpiredBlobMetadata mocks base method.
 func (m *MockMetadataDatabase) GetPageOfExpiredBlobMetadata(arg0 context.Context, arg1 time.Time) ([]core.BlobKey, error) {
 	m.ctrl.T.Helper()
@@ -95,6 +170,21 @@ func (mr *MockMetadataDatabaseMockRecorder) GetPageOfExpiredBlobMetadata(arg0, a
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPageOfExpiredBlobMetadata", reflect.TypeOf((*MockMetadataDatabase)(nil).GetPageOfExpiredBlobMetadata), arg0, arg1)
 }
 
+// GetPageOfSubjectBlobMetadata mocks base method.
+func (m *MockMetadataDatabase) GetPageOfSubjectBlobMetadata(arg0 context.Context, arg1 string) ([]core.BlobKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPageOfSubjectBlobMetadata", arg0, arg1)
+	ret0, _ := ret[0].([]core.BlobKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPageOfSubjectBlobMetadata indicates an expected call of GetPageOfSubjectBlobMetadata.
+func (mr *MockMetadataDatabaseMockRecorder) GetPageOfSubjectBlobMetadata(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPageOfSubjectBlobMetadata", reflect.TypeOf((*MockMetadataDatabase)(nil).GetPageOfSubjectBlobMetadata), arg0, arg1)
+}
+
 // HealthCheck mocks base method.
 func (m *MockMetadataDatabase) HealthCheck(arg0 context.Context) error {
 	m.ctrl.T.Helper()
@@ -110,7 +200,7 @@ func (mr *MockMetadataDatabaseMockRecorder) HealthCheck(arg0 interface{}) *gomoc
 }
 
 // SearchBlobMetadata mocks base method.
-func (m *MockMetadataDatabase) SearchBlobMetadata(arg0 context.Context, arg1 map[string][]string, arg2 *time.Time, arg3 *core.ContinutationToken, arg4 int, arg5 time.Time) ([]core.BlobInfo, *core.ContinutationToken, error) {
+func (m *MockMetadataDatabase) SearchBlobMetadata(arg0 context.Context, arg1 *core.SearchFilter, arg2 core.SortOrder, arg3 *core.ContinutationToken, arg4 int, arg5 time.Time) ([]core.BlobInfo, *core.ContinutationToken, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "SearchBlobMetadata", arg0, arg1, arg2, arg3, arg4, arg5)
 	ret0, _ := ret[0].([]core.BlobInfo)
@@ -140,6 +230,49 @@ func (mr *MockMetadataDatabaseMockRecorder) StageBlobMetadata(arg0, arg1, arg2 i
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StageBlobMetadata", reflect.TypeOf((*MockMetadataDatabase)(nil).StageBlobMetadata), arg0, arg1, arg2)
 }
 
+// StageBlobMetadataForDeletion mocks base method.
+func (m *MockMetadataDatabase) StageBlobMetadataForDeletion(arg0 context.Context, arg1 core.BlobKey) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StageBlobMetadataForDeletion", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// StageBlobMetadataForDeletion indicates an expected call of StageBlobMetadataForDeletion.
+func (mr *MockMetadataDatabaseMockRecorder) StageBlobMetadataForDeletion(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StageBlobMetadataForDeletion", reflect.TypeOf((*MockMetadataDatabase)(nil).StageBlobMetadataForDeletion), arg0, arg1)
+}
+
+// StageSubjectBlobMetadataForDeletion mocks base method.
+func (m *MockMetadataDatabase) StageSubjectBlobMetadataForDeletion(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StageSubjectBlobMetadataForDeletion", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// StageSubjectBlobMetadataForDeletion indicates an expected call of StageSubjectBlobMetadataForDeletion.
+func (mr *MockMetadataDatabaseMockRecorder) StageSubjectBlobMetadataForDeletion(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StageSubjectBlobMetadataForDeletion", reflect.TypeOf((*MockMetadataDatabase)(nil).StageSubjectBlobMetadataForDeletion), arg0, arg1)
+}
+
+// UpdateBlobMetadata mocks base method.
+func (m *MockMetadataDatabase) UpdateBlobMetadata(arg0 context.Context, arg1 core.BlobKey, arg2 *core.BlobMetadataUpdate, arg3 []int64, arg4 time.Time) (*core.BlobInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateBlobMetadata", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(*core.BlobInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateBlobMetadata indicates an expected call of UpdateBlobMetadata.
+func (mr *MockMetadataDatabaseMockRecorder) UpdateBlobMetadata(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateBlobMetadata", reflect.TypeOf((*MockMetadataDatabase)(nil).UpdateBlobMetadata), arg0, arg1, arg2, arg3, arg4)
+}
+
 // MockBlobStore is a mock of BlobStore interface.
 type MockBlobStore struct {
 	ctrl     *gomock.Controller
@@ -177,6 +310,20 @@ func (mr *MockBlobStoreMockRecorder) DeleteBlob(arg0, arg1 interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteBlob", reflect.TypeOf((*MockBlobStore)(nil).DeleteBlob), arg0, arg1)
 }
 
+// DeleteSubject mocks base method.
+func (m *MockBlobStore) DeleteSubject(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteSubject", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteSubject indicates an expected call of DeleteSubject.
+func (mr *MockBlobStoreMockRecorder) DeleteSubject(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteSubject", reflect.TypeOf((*MockBlobStore)(nil).DeleteSubject), arg0, arg1)
+}
+
 // HealthCheck mocks base method.
 func (m *MockBlobStore) HealthCheck(arg0 context.Context) error {
 	m.ctrl.T.Helper()
@@ -191,6 +338,21 @@ func (mr *MockBlobStoreMockRecorder) HealthCheck(arg0 interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HealthCheck", reflect.TypeOf((*MockBlobStore)(nil).HealthCheck), arg0)
 }
 
+// OpenBlob mocks base method.
+func (m *MockBlobStore) OpenBlob(arg0 context.Context, arg1 core.BlobKey) (io.ReadSeekCloser, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "OpenBlob", arg0, arg1)
+	ret0, _ := ret[0].(io.ReadSeekCloser)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// OpenBlob indicates an expected call of OpenBlob.
+func (mr *MockBlobStoreMockRecorder) OpenBlob(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OpenBlob", reflect.TypeOf((*MockBlobStore)(nil).OpenBlob), arg0, arg1)
+}
+
 // ReadBlob mocks base method.
 func (m *MockBlobStore) ReadBlob(arg0 context.Context, arg1 io.Writer, arg2 core.BlobKey) error {
 	m.ctrl.T.Helper()
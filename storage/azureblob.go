@@ -10,6 +10,7 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
 	"github.com/ismrmrd/mrd-storage-server/core"
 	"github.com/rs/zerolog/log"
@@ -17,12 +18,23 @@ import (
 
 var (
 	ErrInvalidConnectionString = errors.New("invalid connection string")
+	errInvalidSeek             = errors.New("invalid seek")
 )
 
 type azureBlobStore struct {
 	containerClient *container.Client
 }
 
+// A seekable reader over a blob. A ranged download starting at the current
+// offset is issued on the first read after opening or seeking.
+type azureBlobReader struct {
+	ctx        context.Context
+	blobClient *blockblob.Client
+	size       int64
+	offset     int64
+	body       io.ReadCloser
+}
+
 func NewAzureBlobStore(connectionString string) (core.BlobStore, error) {
 	containerClient, err := container.NewClientFromConnectionString(connectionString, "mrd-storage-server", nil)
 	if err != nil {
@@ -58,6 +70,20 @@ func (s *azureBlobStore) ReadBlob(ctx context.Context, writer io.Writer, key cor
 	return err
 }
 
+func (s *azureBlobStore) OpenBlob(ctx context.Context, key core.BlobKey) (io.ReadSeekCloser, error) {
+	blobClient := s.containerClient.NewBlockBlobClient(blobName(key))
+	props, err := blobClient.GetProperties(ctx, nil)
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			return nil, core.ErrBlobNotFound
+		}
+
+		return nil, err
+	}
+
+	return &azureBlobReader{ctx: ctx, blobClient: blobClient, size: *props.ContentLength}, nil
+}
+
 func (s *azureBlobStore) DeleteBlob(ctx context.Context, key core.BlobKey) error {
 	blobClient := s.containerClient.NewBlockBlobClient(blobName(key))
 	if _, err := blobClient.Delete(ctx, &azblob.DeleteBlobOptions{}); err != nil {
@@ -69,6 +95,28 @@ func (s *azureBlobStore) DeleteBlob(ctx context.Context, key core.BlobKey) error
 	return nil
 }
 
+func (s *azureBlobStore) DeleteSubject(ctx context.Context, subject string) error {
+	prefix := subjectPrefix(subject) + "/"
+	pager := s.containerClient.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{Prefix: &prefix})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range page.Segment.BlobItems {
+			blobClient := s.containerClient.NewBlobClient(*item.Name)
+			if _, err := blobClient.Delete(ctx, &azblob.DeleteBlobOptions{}); err != nil {
+				if !bloberror.HasCode(err, bloberror.BlobNotFound) {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
 func (s *azureBlobStore) HealthCheck(ctx context.Context) error {
 	_, err := s.containerClient.GetProperties(ctx, nil)
 	if err != nil {
@@ -79,8 +127,69 @@ func (s *azureBlobStore) HealthCheck(ctx context.Context) error {
 	return nil
 }
 
+func (r *azureBlobReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	if r.body == nil {
+		resp, err := r.blobClient.DownloadStream(r.ctx, &blob.DownloadStreamOptions{Range: blob.HTTPRange{Offset: r.offset}})
+		if err != nil {
+			return 0, err
+		}
+
+		r.body = resp.Body
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *azureBlobReader) Seek(offset int64, whence int) (int64, error) {
+	var newOffset int64
+	switch whence {
+	case io.SeekStart:
+		newOffset = offset
+	case io.SeekCurrent:
+		newOffset = r.offset + offset
+	case io.SeekEnd:
+		newOffset = r.size + offset
+	default:
+		return r.offset, errInvalidSeek
+	}
+
+	if newOffset < 0 {
+		return r.offset, errInvalidSeek
+	}
+
+	if newOffset != r.offset {
+		// the next read will start a new download at the new offset
+		if err := r.Close(); err != nil {
+			return r.offset, err
+		}
+
+		r.offset = newOffset
+	}
+
+	return newOffset, nil
+}
+
+func (r *azureBlobReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
+
 func blobName(key core.BlobKey) string {
+	return path.Join(subjectPrefix(key.Subject), key.Id.String())
+}
+
+func subjectPrefix(subject string) string {
 	// make sure we don't have file names / or .. or anything like that
-	encodedSubject := base64.RawURLEncoding.EncodeToString([]byte(key.Subject))
-	return path.Join(encodedSubject, key.Id.String())
+	return base64.RawURLEncoding.EncodeToString([]byte(subject))
 }
@@ -40,10 +40,21 @@ func (s fileSystemStore) SaveBlob(ctx context.Context, contents io.Reader, key c
 		return err
 	}
 
-	defer f.Close()
 	writer := bufio.NewWriter(f)
 	_, err = io.Copy(writer, contents)
-	writer.Flush()
+	if err == nil {
+		err = writer.Flush()
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		// don't leave a partial file behind, since no metadata will refer to it
+		os.Remove(filePath)
+	}
+
 	return err
 }
 
@@ -64,6 +75,18 @@ func (s fileSystemStore) ReadBlob(ctx context.Context, writer io.Writer, key cor
 	return err
 }
 
+func (s fileSystemStore) OpenBlob(ctx context.Context, key core.BlobKey) (io.ReadSeekCloser, error) {
+	f, err := os.Open(s.filename(key))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, core.ErrBlobNotFound
+		}
+		return nil, err
+	}
+
+	return f, nil
+}
+
 func (s fileSystemStore) DeleteBlob(ctx context.Context, key core.BlobKey) error {
 	filePath := s.filename(key)
 
@@ -74,6 +97,10 @@ func (s fileSystemStore) DeleteBlob(ctx context.Context, key core.BlobKey) error
 	return nil
 }
 
+func (s fileSystemStore) DeleteSubject(ctx context.Context, subject string) error {
+	return os.RemoveAll(s.subjectDirectory(subject))
+}
+
 func (s fileSystemStore) HealthCheck(ctx context.Context) error {
 	_, err := os.Stat(s.rootDir)
 	if err != nil {
@@ -84,7 +111,11 @@ func (s fileSystemStore) HealthCheck(ctx context.Context) error {
 }
 
 func (s fileSystemStore) filename(key core.BlobKey) string {
+	return path.Join(s.subjectDirectory(key.Subject), key.Id.String())
+}
+
+func (s fileSystemStore) subjectDirectory(subject string) string {
 	// make sure we don't have file names / or .. or anything like that
-	encodedSubject := base64.RawURLEncoding.EncodeToString([]byte(key.Subject))
-	return path.Join(s.rootDir, encodedSubject, key.Id.String())
+	encodedSubject := base64.RawURLEncoding.EncodeToString([]byte(subject))
+	return path.Join(s.rootDir, encodedSubject)
 }